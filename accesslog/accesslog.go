@@ -0,0 +1,351 @@
+// Package accesslog implements a structured access log with size- and age-based rotation.
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/database64128/opdt-go/jsonhelper"
+)
+
+// flushInterval is the interval between flushes of buffered entries to the log file.
+const flushInterval = time.Second
+
+// queueSize is the number of entries that can wait to be written before new entries are dropped.
+const queueSize = 4096
+
+// backupTimeLayout is the layout of the timestamp in backup file names.
+const backupTimeLayout = "2006-01-02T15-04-05.000"
+
+// Config is the configuration of an access log.
+type Config struct {
+	// Path is the path to the log file. Backups are created in the same directory.
+	Path string `json:"path"`
+
+	// MaxSize is the size in bytes at which the log file is rotated.
+	// If zero, the log file is not rotated by size.
+	MaxSize int64 `json:"maxSize,omitempty"`
+
+	// MaxAge is the age at which the log file is rotated, measured from when it was opened.
+	// If zero, the log file is not rotated by age.
+	MaxAge jsonhelper.Duration `json:"maxAge,omitempty"`
+
+	// MaxBackups is the maximum number of rotated log files to keep.
+	// If zero, all backups are kept, subject to MaxBackupAge.
+	MaxBackups int `json:"maxBackups,omitempty"`
+
+	// MaxBackupAge is the maximum age of rotated log files to keep.
+	// If zero, backups are not removed by age.
+	MaxBackupAge jsonhelper.Duration `json:"maxBackupAge,omitempty"`
+
+	// LogUnauthenticated enables logging packets that were dropped before a key authenticated them,
+	// such as malformed packets, authentication failures and rate-limited requests.
+	// Anyone can send those at line rate, so they are not logged by default.
+	LogUnauthenticated bool `json:"logUnauthenticated,omitempty"`
+}
+
+// Entry is an access log entry.
+type Entry struct {
+	Time          time.Time      `json:"time"`
+	Server        string         `json:"server"`
+	ClientAddress netip.AddrPort `json:"client"`
+	Key           string         `json:"key,omitempty"`
+	Outcome       string         `json:"outcome"`
+}
+
+// Logger writes access log entries as JSON lines.
+//
+// Entries are queued and written by a background goroutine,
+// so that logging never blocks on file I/O or rotation.
+//
+// Logger is safe for concurrent use.
+type Logger struct {
+	config  Config
+	onError func(error)
+	entries chan Entry
+	closed  atomic.Bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	// The fields below are owned by the writer goroutine, and by Close after it has returned.
+	file     *os.File
+	w        *bufio.Writer
+	enc      *json.Encoder
+	size     int64
+	openedAt time.Time
+}
+
+// Open opens the access log file and returns a new logger.
+//
+// onError, if not nil, is called from the writer goroutine with errors that occur while writing entries.
+func (c Config) Open(onError func(error)) (*Logger, error) {
+	l, err := c.open(onError)
+	if err != nil {
+		return nil, err
+	}
+	l.wg.Go(l.writeLoop)
+	return l, nil
+}
+
+// open opens the access log file and returns a new logger without starting the writer goroutine.
+func (c Config) open(onError func(error)) (*Logger, error) {
+	if c.Path == "" {
+		return nil, errors.New("access log path is empty")
+	}
+
+	l := &Logger{
+		config:  c,
+		onError: onError,
+		entries: make(chan Entry, queueSize),
+		done:    make(chan struct{}),
+	}
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Config returns the configuration the logger was opened with.
+func (l *Logger) Config() Config {
+	return l.config
+}
+
+// Log queues the entry to be written to the access log.
+//
+// It returns false if the entry was dropped because the queue is full.
+// Entries logged after the logger is closed are silently dropped.
+func (l *Logger) Log(entry Entry) bool {
+	if l.closed.Load() {
+		return true
+	}
+	select {
+	case l.entries <- entry:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close writes the queued entries, flushes them and closes the log file.
+func (l *Logger) Close() error {
+	if !l.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	close(l.done)
+	l.wg.Wait()
+	return l.closeFile()
+}
+
+// writeLoop writes queued entries and periodically flushes them until the logger is closed.
+func (l *Logger) writeLoop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case entry := <-l.entries:
+			l.write(entry)
+		case <-ticker.C:
+			if l.file != nil {
+				_ = l.w.Flush()
+			}
+		case <-l.done:
+			for {
+				select {
+				case entry := <-l.entries:
+					l.write(entry)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write writes the entry to the log file, reporting errors to l.onError.
+func (l *Logger) write(entry Entry) {
+	if err := l.writeEntry(entry); err != nil && l.onError != nil {
+		l.onError(err)
+	}
+}
+
+// writeEntry writes the entry to the log file, rotating it first if needed.
+func (l *Logger) writeEntry(entry Entry) error {
+	// Retry opening the log file if a previous rotation failed to reopen it.
+	if l.file == nil {
+		if err := l.openFile(); err != nil {
+			return err
+		}
+	}
+
+	if l.shouldRotate(entry.Time) {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n := l.w.Buffered()
+	if err := l.enc.Encode(entry); err != nil {
+		return err
+	}
+	l.size += int64(l.w.Buffered() - n)
+	return nil
+}
+
+// shouldRotate returns whether the log file should be rotated before writing an entry at now.
+func (l *Logger) shouldRotate(now time.Time) bool {
+	if l.size == 0 {
+		return false
+	}
+	if l.config.MaxSize > 0 && l.size >= l.config.MaxSize {
+		return true
+	}
+	if l.config.MaxAge > 0 && now.Sub(l.openedAt) >= time.Duration(l.config.MaxAge) {
+		return true
+	}
+	return false
+}
+
+// openFile opens the log file for appending.
+func (l *Logger) openFile() error {
+	f, err := os.OpenFile(l.config.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.file = f
+	l.w = bufio.NewWriter(f)
+	l.enc = json.NewEncoder(l.w)
+	l.size = fi.Size()
+	l.openedAt = time.Now()
+	return nil
+}
+
+// closeFile flushes and closes the log file.
+func (l *Logger) closeFile() error {
+	if l.file == nil {
+		return nil
+	}
+	err := errors.Join(l.w.Flush(), l.file.Close())
+	l.file = nil
+	return err
+}
+
+// rotate renames the current log file to a timestamped backup,
+// opens a new log file, and removes backups beyond the retention limits.
+func (l *Logger) rotate() error {
+	if err := l.closeFile(); err != nil {
+		return err
+	}
+
+	// Avoid overwriting a backup created within the same millisecond.
+	t := time.Now()
+	backupPath := l.backupPath(t)
+	for {
+		if _, err := os.Lstat(backupPath); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		t = t.Add(time.Millisecond)
+		backupPath = l.backupPath(t)
+	}
+
+	renameErr := os.Rename(l.config.Path, backupPath)
+
+	// Reopen the log file even if the rename failed, so that logging can continue.
+	if err := l.openFile(); err != nil {
+		return errors.Join(renameErr, err)
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	return l.removeOldBackups()
+}
+
+// backupPath returns the path of a backup created at t.
+func (l *Logger) backupPath(t time.Time) string {
+	ext := filepath.Ext(l.config.Path)
+	prefix := strings.TrimSuffix(l.config.Path, ext)
+	return prefix + "-" + t.UTC().Format(backupTimeLayout) + ext
+}
+
+// removeOldBackups removes backups beyond the retention limits.
+func (l *Logger) removeOldBackups() error {
+	if l.config.MaxBackups <= 0 && l.config.MaxBackupAge <= 0 {
+		return nil
+	}
+
+	backups, err := l.listBackups()
+	if err != nil {
+		return err
+	}
+
+	// Newest first.
+	slices.SortFunc(backups, func(a, b backup) int {
+		return b.time.Compare(a.time)
+	})
+
+	now := time.Now()
+	var errs []error
+
+	for i, b := range backups {
+		if (l.config.MaxBackups > 0 && i >= l.config.MaxBackups) ||
+			(l.config.MaxBackupAge > 0 && now.Sub(b.time) > time.Duration(l.config.MaxBackupAge)) {
+			if err := os.Remove(b.path); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// backup is a rotated log file.
+type backup struct {
+	path string
+	time time.Time
+}
+
+// listBackups returns the backups of the log file.
+func (l *Logger) listBackups() ([]backup, error) {
+	ext := filepath.Ext(l.config.Path)
+	prefix := filepath.Base(strings.TrimSuffix(l.config.Path, ext)) + "-"
+	dir := filepath.Dir(l.config.Path)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.Parse(backupTimeLayout, name[len(prefix):len(name)-len(ext)])
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{
+			path: filepath.Join(dir, name),
+			time: t,
+		})
+	}
+	return backups, nil
+}
@@ -0,0 +1,132 @@
+package accesslog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoggerRotation(t *testing.T) {
+	const (
+		entryCount = 20
+		maxBackups = 3
+	)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+
+	l, err := Config{
+		Path:       path,
+		MaxSize:    1,
+		MaxBackups: maxBackups,
+	}.Open(func(err error) {
+		t.Errorf("Failed to write entry: %v", err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientAddrPort := netip.MustParseAddrPort("[2001:db8::1]:20220")
+	for i := range entryCount {
+		if !l.Log(Entry{
+			Time:          time.Now(),
+			Server:        "test",
+			ClientAddress: clientAddrPort,
+			Key:           "alice",
+			Outcome:       "handled",
+		}) {
+			t.Fatalf("Entry %d was dropped", i)
+		}
+	}
+
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := l.listBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != maxBackups {
+		t.Errorf("Got %d backups, expected %d", len(backups), maxBackups)
+	}
+
+	// With a 1-byte size limit, each file holds exactly one entry.
+	for _, p := range append([]string{path}, backupPaths(backups)...) {
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var lines int
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			lines++
+			var entry Entry
+			if err := json.Unmarshal(s.Bytes(), &entry); err != nil {
+				t.Errorf("%s: failed to decode entry: %v", p, err)
+			}
+			if entry.ClientAddress != clientAddrPort || entry.Key != "alice" || entry.Outcome != "handled" {
+				t.Errorf("%s: unexpected entry: %+v", p, entry)
+			}
+		}
+		f.Close()
+
+		if lines != 1 {
+			t.Errorf("%s: got %d lines, expected 1", p, lines)
+		}
+	}
+}
+
+func TestLoggerDropsOnOverflow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	// Without the writer goroutine, nothing drains the queue.
+	l, err := Config{Path: path}.open(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := Entry{
+		Time:          time.Now(),
+		Server:        "test",
+		ClientAddress: netip.MustParseAddrPort("[2001:db8::1]:20220"),
+		Key:           "alice",
+		Outcome:       "handled",
+	}
+	for i := range queueSize {
+		if !l.Log(entry) {
+			t.Fatalf("Entry %d was dropped, expected the queue to hold %d entries", i, queueSize)
+		}
+	}
+	if l.Log(entry) {
+		t.Error("Entry was queued, expected it to be dropped when the queue is full")
+	}
+
+	// Close writes the queued entries.
+	l.wg.Go(l.writeLoop)
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(b, []byte("\n")); lines != queueSize {
+		t.Errorf("Got %d lines, expected %d", lines, queueSize)
+	}
+}
+
+func backupPaths(backups []backup) []string {
+	paths := make([]string, len(backups))
+	for i, b := range backups {
+		paths[i] = b.path
+	}
+	return paths
+}
@@ -8,15 +8,20 @@ import (
 	"net/netip"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/database64128/opdt-go/conn"
 	"github.com/database64128/opdt-go/packet"
+	"github.com/database64128/opdt-go/secret"
 )
 
 const (
 	defaultInterval        = 2 * time.Second
 	defaultOneShotAttempts = 5
+	defaultCooldown        = 5 * time.Minute
+
+	defaultFailoverAfterFailures = 5
 )
 
 type Error struct {
@@ -36,7 +41,12 @@ func (e Error) Error() string {
 
 type Result struct {
 	ClientAddrPort netip.AddrPort
-	Err            Error
+
+	// ServerAddrPort is the server address the response came from,
+	// or for errors, the address of the server that was queried, if it is known.
+	ServerAddrPort netip.AddrPort
+
+	Err Error
 }
 
 func (r Result) IsOk() bool {
@@ -53,33 +63,207 @@ func ErrResult(err Error) Result {
 
 type Config struct {
 	ServerAddrPort netip.AddrPort
-	BindAddress    string
-	PSK            []byte
+
+	// ServerAddress is the server address in the form "host:port", used when ServerAddrPort is not valid.
+	// A host name is resolved when the client starts sending, and re-resolved periodically,
+	// and after repeated requests without a response.
+	ServerAddress string
+
+	BindAddress string
+	PSK         secret.Key
+
+	// ResolveInterval is the interval between resolutions of server host names.
+	// If zero, host names are re-resolved every 10 minutes.
+	ResolveInterval time.Duration
+
+	// ResolveAfterFailures is the number of consecutive requests without a response
+	// after which server host names are re-resolved.
+	// If zero, host names are re-resolved after 3 requests without a response.
+	ResolveAfterFailures int
+
+	// FallbackServers are tried in order when the server, or the previous fallback server,
+	// times out or only returns errors. A fallback server without a PSK uses PSK.
+	FallbackServers []ServerConfig
+
+	// Cooldown is how long a server that failed is skipped in favor of the next one.
+	// If zero, failed servers are skipped for 5 minutes.
+	Cooldown time.Duration
+
+	// FailoverAfterFailures is the number of consecutive intervals without a response
+	// after which [Client.Run] and [Client.Watch] put the server in a cool-down period,
+	// and switch to the next one.
+	// If zero, they switch after 5 intervals without a response.
+	FailoverAfterFailures int
+
+	// SRVDomain is the domain to discover servers from, using the SRV records of "_opdt._udp.<domain>".
+	// Discovered servers are tried after the configured servers, in the order of priority and weight,
+	// and are rediscovered every ResolveInterval. They use PSK.
+	SRVDomain string
+
+	// Resolver resolves server host names and SRV records.
+	// If nil, [net.DefaultResolver] is used.
+	Resolver *net.Resolver
+
+	// SendLocalAddress controls whether requests carry the client's local address,
+	// so that the server can record which local host is behind the observed address.
+	// The local address is encrypted with the rest of the request.
+	SendLocalAddress bool
 }
 
 func (c Config) Client() (*Client, error) {
+	return c.client("udp")
+}
+
+// client returns a new client with a socket listening on the given network.
+func (c Config) client(network string) (*Client, error) {
 	handler, err := packet.NewClient(c.PSK)
 	if err != nil {
 		return nil, err
 	}
-	pc, err := net.ListenPacket("udp", c.BindAddress)
+
+	servers := make([]*server, 0, 1+len(c.FallbackServers))
+
+	if c.ServerAddrPort.IsValid() || c.ServerAddress != "" || c.SRVDomain == "" {
+		primary, err := newServer(c.ServerAddrPort, c.ServerAddress, handler)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, primary)
+	}
+
+	for _, sc := range c.FallbackServers {
+		psk := sc.PSK
+		if len(psk) == 0 {
+			psk = c.PSK
+		}
+		handler, err := packet.NewClient(psk)
+		if err != nil {
+			return nil, fmt.Errorf("bad PSK for server %s%s: %w", sc.AddrPort, sc.Address, err)
+		}
+		s, err := newServer(sc.AddrPort, sc.Address, handler)
+		if err != nil {
+			return nil, fmt.Errorf("bad fallback server: %w", err)
+		}
+		servers = append(servers, s)
+	}
+
+	cooldown := c.Cooldown
+	if cooldown == 0 {
+		cooldown = defaultCooldown
+	}
+	resolveInterval := c.ResolveInterval
+	if resolveInterval == 0 {
+		resolveInterval = defaultResolveInterval
+	}
+	resolveAfterFailures := c.ResolveAfterFailures
+	if resolveAfterFailures == 0 {
+		resolveAfterFailures = defaultResolveAfterFailures
+	}
+	failoverAfterFailures := c.FailoverAfterFailures
+	if failoverAfterFailures == 0 {
+		failoverAfterFailures = defaultFailoverAfterFailures
+	}
+	resolver := c.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	pc, err := net.ListenPacket(network, c.BindAddress)
 	if err != nil {
 		return nil, err
 	}
+	serverConn := pc.(*net.UDPConn)
+
 	return &Client{
-		serverAddrPort: c.ServerAddrPort,
-		serverConn:     pc.(*net.UDPConn),
-		handler:        handler,
+		servers:              servers,
+		staticServerCount:    len(servers),
+		serverConn:           serverConn,
+		handler:              handler,
+		sendLocalAddress:     c.SendLocalAddress,
+		cooldown:             cooldown,
+		failoverAfter:        failoverAfterFailures,
+		srvDomain:            c.SRVDomain,
+		resolver:             resolver,
+		resolveNetwork:       resolveNetwork(network, serverConn.LocalAddr().(*net.UDPAddr).AddrPort()),
+		resolveInterval:      resolveInterval,
+		resolveAfterFailures: int32(resolveAfterFailures),
 	}, nil
 }
 
 type Client struct {
-	serverAddrPort netip.AddrPort
-	serverConn     *net.UDPConn
-	handler        *packet.Client
+	// mu protects servers and discoveredAt.
+	mu           sync.Mutex
+	servers      []*server
+	discoveredAt time.Time
+
+	// staticServerCount is the number of configured servers at the start of servers.
+	// The rest are discovered from SRV records.
+	staticServerCount int
+
+	serverConn           *net.UDPConn
+	handler              *packet.Client
+	sendLocalAddress     bool
+	cooldown             time.Duration
+	failoverAfter        int
+	srvDomain            string
+	resolver             *net.Resolver
+	resolveNetwork       string
+	resolveInterval      time.Duration
+	resolveAfterFailures int32
+}
+
+// localAddrPort returns the local address of the client socket.
+// If the socket is bound to an unspecified address, the address is replaced by
+// the source address the system would choose to reach the server.
+func (c *Client) localAddrPort(serverAddrPort netip.AddrPort) (netip.AddrPort, error) {
+	localAddrPort := c.serverConn.LocalAddr().(*net.UDPAddr).AddrPort()
+	if !localAddrPort.Addr().IsUnspecified() {
+		return localAddrPort, nil
+	}
+
+	// Connecting a UDP socket selects the source address without sending anything.
+	rc, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(serverAddrPort))
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	defer rc.Close()
+
+	addr := rc.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap()
+	return netip.AddrPortFrom(addr, localAddrPort.Port()), nil
+}
+
+// putRequest writes a request packet for the server to reqBuf and returns the packet.
+// If the client is configured to send its local address but fails to determine it,
+// a request without the local address is written, along with the error.
+func (c *Client) putRequest(reqBuf []byte, s *server) ([]byte, error) {
+	if !c.sendLocalAddress {
+		s.handler.PutRequest(reqBuf)
+		return reqBuf[:packet.RequestPacketSize], nil
+	}
+
+	localAddrPort, err := c.localAddrPort(s.AddrPort())
+	if err != nil {
+		s.handler.PutRequest(reqBuf)
+		return reqBuf[:packet.RequestPacketSize], err
+	}
+
+	s.handler.PutRequestWithLocalAddress(reqBuf, localAddrPort)
+	return reqBuf[:packet.RequestWithLocalAddressPacketSize], nil
 }
 
+// Get returns the client address discovered by the first server that responds.
+//
+// Servers are tried in order, skipping servers in a cool-down period unless all of them are.
+// Each server is sent up to attempts requests at the given interval. A server that times out
+// or only returns errors is put in a cool-down period, and the next server is tried.
 func (c *Client) Get(ctx context.Context, interval time.Duration, attempts int) (netip.AddrPort, error) {
+	result, err := c.GetResult(ctx, interval, attempts)
+	return result.ClientAddrPort, err
+}
+
+// GetResult is like [Client.Get], but returns the result, which also holds the address of the server
+// that responded, or on failure, of the last server tried.
+func (c *Client) GetResult(ctx context.Context, interval time.Duration, attempts int) (Result, error) {
 	if interval == 0 {
 		interval = defaultInterval
 	}
@@ -87,34 +271,89 @@ func (c *Client) Get(ctx context.Context, interval time.Duration, attempts int)
 		attempts = defaultOneShotAttempts
 	}
 
+	var errs []error
+	if err := c.discover(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	servers := c.serverOrder(time.Now())
+	if len(servers) == 0 {
+		return Result{}, errors.Join(errs...)
+	}
+
+	var result Result
+	for _, s := range servers {
+		var err error
+		if result, err = c.getFrom(ctx, interval, attempts, s); err == nil {
+			return result, nil
+		}
+		if len(servers) == 1 && len(errs) == 0 {
+			return result, err
+		}
+
+		errs = append(errs, fmt.Errorf("server %s: %w", s, err))
+		if ctx.Err() != nil {
+			break
+		}
+		s.markUnhealthy(time.Now().Add(c.cooldown))
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// getFrom sends up to attempts requests to the server at the given interval,
+// and returns the first successful result, or an error result from the server.
+func (c *Client) getFrom(ctx context.Context, interval time.Duration, attempts int, s *server) (Result, error) {
 	ctx, cancel := context.WithTimeout(ctx, interval*time.Duration(attempts))
 	defer cancel()
 
-	resultCh, err := c.Run(ctx, interval)
+	resultCh, err := c.run(ctx, interval, s)
 	if err != nil {
-		return netip.AddrPort{}, err
+		return s.errResult(Error{}), err
 	}
 
-	var clientErr Error
+	var (
+		okResult  Result
+		clientErr Error
+	)
 
+	// Drain the channel after the first valid response, so that the socket is no longer
+	// in use by the run when we return.
 	for result := range resultCh {
-		if result.IsOk() {
-			return result.ClientAddrPort, nil
+		switch {
+		case okResult.IsOk():
+		case result.IsOk():
+			okResult = result
+			cancel()
+		default:
+			clientErr = result.Err
 		}
-		clientErr = result.Err
 	}
 
+	if okResult.IsOk() {
+		return okResult, nil
+	}
 	if clientErr.Err == nil {
-		return netip.AddrPort{}, context.DeadlineExceeded
+		return s.errResult(Error{}), context.DeadlineExceeded
 	}
-	return netip.AddrPort{}, clientErr
+	return s.errResult(clientErr), clientErr
 }
 
+// Run keeps sending requests at the given interval to the first server not in a cool-down period,
+// and returns a channel that receives each result. The channel is closed when ctx is canceled.
+//
+// A server that does not respond for FailoverAfterFailures intervals is put in a cool-down period,
+// and requests are sent to the next server. When the cool-down period of a preferred server ends,
+// requests are sent to it again.
 func (c *Client) Run(ctx context.Context, interval time.Duration) (<-chan Result, error) {
 	if interval == 0 {
 		interval = defaultInterval
 	}
+	return c.runWithFailover(ctx, interval, new(atomic.Pointer[server]))
+}
 
+// run keeps sending requests to the server at the given interval.
+func (c *Client) run(ctx context.Context, interval time.Duration, s *server) (<-chan Result, error) {
 	if err := c.serverConn.SetReadDeadline(time.Time{}); err != nil {
 		return nil, err
 	}
@@ -122,14 +361,33 @@ func (c *Client) Run(ctx context.Context, interval time.Duration) (<-chan Result
 	resultCh := make(chan Result)
 	var wg sync.WaitGroup
 
+	// unanswered is the number of requests sent since the last valid response.
+	var unanswered atomic.Int32
+
 	wg.Go(func() {
-		reqBuf := make([]byte, packet.RequestPacketSize)
+		reqBuf := make([]byte, packet.MaxRequestPacketSize)
 
 		for {
-			c.handler.PutRequest(reqBuf)
+			// After repeated failures, move on to the next address of the server.
+			failing := s.host != "" && unanswered.Load() >= c.resolveAfterFailures
+			if failing || s.needsResolve(time.Now(), c.resolveInterval) {
+				unanswered.Store(0)
+				if _, err := s.resolve(ctx, c.resolver, c.resolveNetwork, failing); err != nil {
+					resultCh <- s.errResult(Error{Message: "failed to resolve server address", Err: err})
+				}
+			}
 
-			if _, err := c.serverConn.WriteToUDPAddrPort(reqBuf, c.serverAddrPort); err != nil {
-				resultCh <- ErrResult(Error{Message: "failed to send request", PeerAddrPort: c.serverAddrPort, PacketLength: packet.RequestPacketSize, Err: err})
+			if serverAddrPort := s.AddrPort(); serverAddrPort.IsValid() {
+				req, err := c.putRequest(reqBuf, s)
+				if err != nil {
+					resultCh <- s.errResult(Error{Message: "failed to determine local address", PeerAddrPort: serverAddrPort, PacketLength: len(req), Err: err})
+				}
+
+				if _, err = c.serverConn.WriteToUDPAddrPort(req, serverAddrPort); err != nil {
+					resultCh <- s.errResult(Error{Message: "failed to send request", PeerAddrPort: serverAddrPort, PacketLength: len(req), Err: err})
+				}
+
+				unanswered.Add(1)
 			}
 
 			select {
@@ -149,21 +407,33 @@ func (c *Client) Run(ctx context.Context, interval time.Duration) (<-chan Result
 				if errors.Is(err, os.ErrDeadlineExceeded) {
 					return
 				}
-				resultCh <- ErrResult(Error{Message: "failed to receive packet", PeerAddrPort: packetSourceAddrPort, PacketLength: n, Err: err})
+				resultCh <- s.errResult(Error{Message: "failed to receive packet", PeerAddrPort: packetSourceAddrPort, PacketLength: n, Err: err})
+				continue
+			}
+
+			// Only the queried server may answer, even if another host knows the PSK.
+			packetSourceAddrPort = unmapAddrPort(packetSourceAddrPort)
+			if packetSourceAddrPort != unmapAddrPort(s.AddrPort()) {
 				continue
 			}
+
 			if err = conn.ParseFlagsForError(flags); err != nil {
-				resultCh <- ErrResult(Error{Message: "failed to receive packet", PeerAddrPort: packetSourceAddrPort, PacketLength: n, Err: err})
+				resultCh <- s.errResult(Error{Message: "failed to receive packet", PeerAddrPort: packetSourceAddrPort, PacketLength: n, Err: err})
 				continue
 			}
 
-			clientAddrPort, err := c.handler.ParseResponse(respBuf[:n])
+			clientAddrPort, err := s.handler.ParseResponse(respBuf[:n])
 			if err != nil {
-				resultCh <- ErrResult(Error{Message: "failed to parse response", PeerAddrPort: packetSourceAddrPort, PacketLength: n, Err: err})
+				resultCh <- s.errResult(Error{Message: "failed to parse response", PeerAddrPort: packetSourceAddrPort, PacketLength: n, Err: err})
 				continue
 			}
 
-			resultCh <- OkResult(clientAddrPort)
+			s.markHealthy()
+			unanswered.Store(0)
+
+			result := OkResult(clientAddrPort)
+			result.ServerAddrPort = packetSourceAddrPort
+			resultCh <- result
 
 			select {
 			case <-ctx.Done():
@@ -0,0 +1,340 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/database64128/opdt-go/packet"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// listenTestServer starts a server on the loopback address addr that responds to requests
+// if handler is not nil, and silently drops them otherwise.
+func listenTestServer(t *testing.T, addr netip.Addr, handler *packet.Server) netip.AddrPort {
+	t.Helper()
+	pc, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		req := make([]byte, packet.MaxRequestPacketSize)
+		resp := make([]byte, packet.ResponsePacketSize)
+		for {
+			n, clientAddrPort, err := pc.ReadFromUDPAddrPort(req)
+			if err != nil {
+				return
+			}
+			if handler == nil {
+				continue
+			}
+			if _, _, err = handler.Handle(clientAddrPort, req[:n], resp); err != nil {
+				continue
+			}
+			pc.WriteToUDPAddrPort(resp, clientAddrPort)
+		}
+	}()
+
+	return pc.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func TestClientGetFailover(t *testing.T) {
+	psk := make([]byte, chacha20poly1305.KeySize)
+	rand.Read(psk)
+	handler, err := packet.NewServer(psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loopback := netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	deadAddrPort := listenTestServer(t, loopback, nil)
+	liveAddrPort := listenTestServer(t, loopback, handler)
+
+	c, err := Config{
+		ServerAddrPort:  deadAddrPort,
+		BindAddress:     "127.0.0.1:0",
+		PSK:             psk,
+		FallbackServers: []ServerConfig{{AddrPort: liveAddrPort}},
+		Cooldown:        time.Minute,
+	}.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	localAddrPort := c.serverConn.LocalAddr().(*net.UDPAddr).AddrPort()
+
+	const interval = 50 * time.Millisecond
+
+	clientAddrPort, err := c.Get(context.Background(), interval, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientAddrPort != localAddrPort {
+		t.Errorf("Got client address %s, expected %s", clientAddrPort, localAddrPort)
+	}
+
+	// The dead server is in its cool-down period, so the live server is tried first.
+	if s := c.serverOrder(time.Now())[0]; s.addrPort != liveAddrPort {
+		t.Errorf("Got first server %s, expected %s", s.addrPort, liveAddrPort)
+	}
+
+	start := time.Now()
+	if _, err = c.Get(context.Background(), interval, 2); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= interval {
+		t.Errorf("Get took %s, expected the live server to be tried first", elapsed)
+	}
+}
+
+func TestClientIgnoresOtherSources(t *testing.T) {
+	psk := make([]byte, chacha20poly1305.KeySize)
+	rand.Read(psk)
+	handler, err := packet.NewServer(psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spoofClient, err := packet.NewClient(psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverAddrPort := listenTestServer(t, netip.AddrFrom4([4]byte{127, 0, 0, 1}), nil)
+
+	c, err := Config{
+		ServerAddrPort: serverAddrPort,
+		BindAddress:    "127.0.0.1:0",
+		PSK:            psk,
+	}.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	localAddrPort := c.serverConn.LocalAddr().(*net.UDPAddr).AddrPort()
+
+	// Another host that knows the PSK answers in place of the silent server.
+	spoofConn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.MustParseAddrPort("127.0.0.1:0")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spoofConn.Close()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	go func() {
+		req := make([]byte, packet.RequestPacketSize)
+		resp := make([]byte, packet.ResponsePacketSize)
+		spoofedAddrPort := netip.MustParseAddrPort("192.0.2.1:20220")
+		for ctx.Err() == nil {
+			spoofClient.PutRequest(req)
+			if _, _, err := handler.Handle(spoofedAddrPort, req, resp); err != nil {
+				return
+			}
+			spoofConn.WriteToUDPAddrPort(resp, localAddrPort)
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	if result, err := c.GetResult(ctx, 50*time.Millisecond, 3); err == nil {
+		t.Errorf("Got client address %s from %s, expected responses from other sources to be ignored", result.ClientAddrPort, result.ServerAddrPort)
+	}
+}
+
+func TestClientRunFailover(t *testing.T) {
+	psk := make([]byte, chacha20poly1305.KeySize)
+	rand.Read(psk)
+	handler, err := packet.NewServer(psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loopback := netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	deadAddrPort := listenTestServer(t, loopback, nil)
+	liveAddrPort := listenTestServer(t, loopback, handler)
+
+	const (
+		interval = 20 * time.Millisecond
+		cooldown = 200 * time.Millisecond
+	)
+
+	c, err := Config{
+		ServerAddrPort:        deadAddrPort,
+		BindAddress:           "127.0.0.1:0",
+		PSK:                   psk,
+		FallbackServers:       []ServerConfig{{AddrPort: liveAddrPort}},
+		Cooldown:              cooldown,
+		FailoverAfterFailures: 2,
+	}.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 2*time.Second)
+	defer cancel()
+
+	var current atomic.Pointer[server]
+	resultCh, err := c.runWithFailover(ctx, interval, &current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cancel()
+		for range resultCh {
+		}
+	}()
+
+	// The dead server never responds, so the client switches to the live server.
+	for result := range resultCh {
+		if result.IsOk() {
+			if result.ServerAddrPort != liveAddrPort {
+				t.Fatalf("Got result from %s, expected %s", result.ServerAddrPort, liveAddrPort)
+			}
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		t.Fatal("Client did not switch to the live server")
+	}
+
+	// When the cool-down period of the preferred server ends, the client switches back to it.
+	// The preferred server is only used until it fails again, so poll while draining results.
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for current.Load().AddrPort() != deadAddrPort {
+		select {
+		case <-ctx.Done():
+			t.Fatal("Client did not switch back to the preferred server after its cool-down period")
+		case <-resultCh:
+		case <-ticker.C:
+		}
+	}
+}
+
+func TestDualStackClientGet(t *testing.T) {
+	psk := make([]byte, chacha20poly1305.KeySize)
+	rand.Read(psk)
+	handler, err := packet.NewServer(psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipv4AddrPort := listenTestServer(t, netip.AddrFrom4([4]byte{127, 0, 0, 1}), handler)
+
+	// Without an IPv6 server address, only IPv4 works.
+	c, err := DualStackConfig{
+		IPv4ServerAddrPort: ipv4AddrPort,
+		PSK:                psk,
+	}.DualStackClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	r := c.Get(context.Background(), 50*time.Millisecond, 2)
+	if !r.IsOk() {
+		t.Fatal("Expected at least one family to work")
+	}
+	if !r.IPv4.IsOk() || !r.IPv4.ClientAddrPort.Addr().Is4() {
+		t.Errorf("Got IPv4 result %v, expected an IPv4 client address", r.IPv4)
+	}
+	if r.IPv6.IsOk() || !errors.Is(r.IPv6.Err, ErrNoServerAddress) {
+		t.Errorf("Got IPv6 result %v, expected %v", r.IPv6, ErrNoServerAddress)
+	}
+}
+
+func TestClientGetHostName(t *testing.T) {
+	psk := make([]byte, chacha20poly1305.KeySize)
+	rand.Read(psk)
+	handler, err := packet.NewServer(psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverAddrPort := listenTestServer(t, netip.AddrFrom4([4]byte{127, 0, 0, 1}), handler)
+
+	c, err := Config{
+		ServerAddress: net.JoinHostPort("localhost", strconv.FormatUint(uint64(serverAddrPort.Port()), 10)),
+		BindAddress:   "127.0.0.1:0",
+		PSK:           psk,
+	}.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	result, err := c.GetResult(context.Background(), 50*time.Millisecond, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ServerAddrPort != serverAddrPort {
+		t.Errorf("Got server address %s, expected %s", result.ServerAddrPort, serverAddrPort)
+	}
+}
+
+func TestClientGetResultError(t *testing.T) {
+	psk := make([]byte, chacha20poly1305.KeySize)
+	rand.Read(psk)
+
+	deadAddrPort := listenTestServer(t, netip.AddrFrom4([4]byte{127, 0, 0, 1}), nil)
+
+	c, err := Config{
+		ServerAddrPort: deadAddrPort,
+		BindAddress:    "127.0.0.1:0",
+		PSK:            psk,
+	}.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	result, err := c.GetResult(context.Background(), 20*time.Millisecond, 2)
+	if err == nil {
+		t.Fatal("Expected the dead server to time out")
+	}
+	if result.ServerAddrPort != deadAddrPort {
+		t.Errorf("Got server address %s, expected %s", result.ServerAddrPort, deadAddrPort)
+	}
+}
+
+func TestServerResolveNext(t *testing.T) {
+	resolver := listenTestResolver(t, "", nil, [4]byte{127, 0, 0, 1}, [4]byte{127, 0, 0, 2})
+
+	s, err := newServer(netip.AddrPort{}, "opdt.example.test:20220", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := s.resolve(t.Context(), resolver, "ip4", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A periodic resolve keeps the current address.
+	if addrPort, err := s.resolve(t.Context(), resolver, "ip4", false); err != nil || addrPort != first {
+		t.Errorf("Got %s, %v, expected %s to be kept", addrPort, err, first)
+	}
+
+	// After failures, each address is tried in turn.
+	second, err := s.resolve(t.Context(), resolver, "ip4", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Errorf("Got %s again, expected the next address", second)
+	}
+	if addrPort, err := s.resolve(t.Context(), resolver, "ip4", true); err != nil || addrPort != first {
+		t.Errorf("Got %s, %v, expected to wrap around to %s", addrPort, err, first)
+	}
+}
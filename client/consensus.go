@@ -0,0 +1,318 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/database64128/opdt-go/conn"
+	"github.com/database64128/opdt-go/packet"
+)
+
+var (
+	ErrNoServers       = errors.New("no servers configured")
+	ErrDuplicateServer = errors.New("duplicate server address")
+	ErrBadQuorum       = errors.New("quorum must be between 1 and the number of servers")
+	ErrNoQuorum        = errors.New("servers did not reach a quorum")
+	ErrNoResponse      = errors.New("no response")
+)
+
+// ConsensusConfig is the configuration of a [ConsensusClient].
+type ConsensusConfig struct {
+	Servers     []ServerConfig
+	BindAddress string
+
+	// Quorum is the number of servers that must report the same client address.
+	// If zero, a majority of the servers is required.
+	Quorum int
+}
+
+// ConsensusClient queries several servers from the same socket,
+// and only reports the client address when a quorum of them agree.
+type ConsensusClient struct {
+	serverConn *net.UDPConn
+	servers    []consensusServer
+	quorum     int
+}
+
+type consensusServer struct {
+	addrPort netip.AddrPort
+	handler  *packet.Client
+}
+
+// ConsensusClient returns a new consensus client.
+func (c ConsensusConfig) ConsensusClient() (*ConsensusClient, error) {
+	if len(c.Servers) == 0 {
+		return nil, ErrNoServers
+	}
+
+	quorum := c.Quorum
+	if quorum == 0 {
+		quorum = len(c.Servers)/2 + 1
+	}
+	if quorum < 1 || quorum > len(c.Servers) {
+		return nil, fmt.Errorf("%w: %d", ErrBadQuorum, c.Quorum)
+	}
+
+	servers := make([]consensusServer, len(c.Servers))
+	for i, sc := range c.Servers {
+		if !sc.AddrPort.IsValid() {
+			return nil, fmt.Errorf("%w: consensus servers must be configured by IP address, got %q", ErrNoServerAddress, sc.Address)
+		}
+		addrPort := unmapAddrPort(sc.AddrPort)
+		for _, s := range servers[:i] {
+			if s.addrPort == addrPort {
+				return nil, fmt.Errorf("%w: %s", ErrDuplicateServer, addrPort)
+			}
+		}
+
+		handler, err := packet.NewClient(sc.PSK)
+		if err != nil {
+			return nil, fmt.Errorf("bad PSK for server %s: %w", sc.AddrPort, err)
+		}
+
+		servers[i] = consensusServer{
+			addrPort: addrPort,
+			handler:  handler,
+		}
+	}
+
+	pc, err := net.ListenPacket("udp", c.BindAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConsensusClient{
+		serverConn: pc.(*net.UDPConn),
+		servers:    servers,
+		quorum:     quorum,
+	}, nil
+}
+
+// ServerResponse is the outcome of querying one server.
+type ServerResponse struct {
+	ServerAddrPort netip.AddrPort
+	ClientAddrPort netip.AddrPort
+	Err            error
+}
+
+// Vote is a client address and the servers that reported it.
+type Vote struct {
+	ClientAddrPort netip.AddrPort
+	Servers        []netip.AddrPort
+}
+
+// ConsensusResult is the outcome of a consensus query.
+type ConsensusResult struct {
+	// Responses holds the response of each server, in the configured order.
+	Responses []ServerResponse
+
+	// Votes holds the distinct client addresses reported, by descending number of servers.
+	Votes []Vote
+
+	// Quorum is the number of servers that must agree.
+	Quorum int
+}
+
+// newConsensusResult tallies the responses.
+func newConsensusResult(responses []ServerResponse, quorum int) ConsensusResult {
+	var votes []Vote
+	for _, r := range responses {
+		if r.Err != nil {
+			continue
+		}
+		i := slices.IndexFunc(votes, func(v Vote) bool {
+			return v.ClientAddrPort == r.ClientAddrPort
+		})
+		if i == -1 {
+			i = len(votes)
+			votes = append(votes, Vote{ClientAddrPort: r.ClientAddrPort})
+		}
+		votes[i].Servers = append(votes[i].Servers, r.ServerAddrPort)
+	}
+
+	slices.SortStableFunc(votes, func(a, b Vote) int {
+		return cmp.Compare(len(b.Servers), len(a.Servers))
+	})
+
+	return ConsensusResult{
+		Responses: responses,
+		Votes:     votes,
+		Quorum:    quorum,
+	}
+}
+
+// ClientAddrPort returns the client address reported by a quorum of servers.
+// If no address, or more than one address, reaches the quorum, it returns an error
+// that wraps [ErrNoQuorum] and describes the disagreement.
+func (r ConsensusResult) ClientAddrPort() (netip.AddrPort, error) {
+	if len(r.Votes) > 0 && len(r.Votes[0].Servers) >= r.Quorum &&
+		(len(r.Votes) == 1 || len(r.Votes[1].Servers) < r.Quorum) {
+		return r.Votes[0].ClientAddrPort, nil
+	}
+	return netip.AddrPort{}, fmt.Errorf("%w of %d: %s", ErrNoQuorum, r.Quorum, r.describe())
+}
+
+// DestinationDependent reports whether servers reported different client ports for the same IP address,
+// which indicates that the NAT mapping depends on the destination.
+func (r ConsensusResult) DestinationDependent() bool {
+	for i, a := range r.Votes {
+		for _, b := range r.Votes[i+1:] {
+			if a.ClientAddrPort.Addr() == b.ClientAddrPort.Addr() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// describe returns a summary of the votes and failed servers.
+func (r ConsensusResult) describe() string {
+	var b strings.Builder
+	for _, v := range r.Votes {
+		if b.Len() > 0 {
+			b.WriteString("; ")
+		}
+		fmt.Fprintf(&b, "%s from %d of %d servers (%s)", v.ClientAddrPort, len(v.Servers), len(r.Responses), joinAddrPorts(v.Servers))
+	}
+	for _, resp := range r.Responses {
+		if resp.Err == nil {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("; ")
+		}
+		fmt.Fprintf(&b, "%s: %v", resp.ServerAddrPort, resp.Err)
+	}
+	return b.String()
+}
+
+func joinAddrPorts(addrPorts []netip.AddrPort) string {
+	s := make([]string, len(addrPorts))
+	for i, addrPort := range addrPorts {
+		s[i] = addrPort.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// unmapAddrPort returns addrPort with any IPv4-mapped IPv6 address unmapped.
+func unmapAddrPort(addrPort netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())
+}
+
+// Query sends requests to all servers at the given interval, until every server has responded,
+// or the given number of attempts have been sent, and returns the tallied result.
+func (c *ConsensusClient) Query(ctx context.Context, interval time.Duration, attempts int) (ConsensusResult, error) {
+	if interval == 0 {
+		interval = defaultInterval
+	}
+	if attempts == 0 {
+		attempts = defaultOneShotAttempts
+	}
+
+	if err := c.serverConn.SetReadDeadline(time.Time{}); err != nil {
+		return ConsensusResult{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, interval*time.Duration(attempts))
+	defer cancel()
+
+	responses := make([]ServerResponse, len(c.servers))
+	answered := make([]atomic.Bool, len(c.servers))
+	for i, s := range c.servers {
+		responses[i].ServerAddrPort = s.addrPort
+	}
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		reqBuf := make([]byte, packet.RequestPacketSize)
+
+		for {
+			for i, s := range c.servers {
+				if answered[i].Load() {
+					continue
+				}
+				s.handler.PutRequest(reqBuf)
+				if _, err := c.serverConn.WriteToUDPAddrPort(reqBuf, s.addrPort); err != nil {
+					responses[i].Err = fmt.Errorf("failed to send request: %w", err)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	})
+
+	stop := context.AfterFunc(ctx, func() {
+		c.serverConn.SetReadDeadline(conn.ALongTimeAgo)
+	})
+
+	respBuf := make([]byte, packet.ResponsePacketSize)
+	recvErrs := make([]error, len(c.servers))
+
+	for pending := len(c.servers); pending > 0; {
+		n, _, flags, packetSourceAddrPort, err := c.serverConn.ReadMsgUDPAddrPort(respBuf, nil)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			continue
+		}
+
+		i := slices.IndexFunc(c.servers, func(s consensusServer) bool {
+			return s.addrPort == unmapAddrPort(packetSourceAddrPort)
+		})
+		if i == -1 || answered[i].Load() {
+			continue
+		}
+
+		if err = conn.ParseFlagsForError(flags); err != nil {
+			recvErrs[i] = Error{Message: "failed to receive packet", PeerAddrPort: packetSourceAddrPort, PacketLength: n, Err: err}
+			continue
+		}
+
+		clientAddrPort, err := c.servers[i].handler.ParseResponse(respBuf[:n])
+		if err != nil {
+			recvErrs[i] = Error{Message: "failed to parse response", PeerAddrPort: packetSourceAddrPort, PacketLength: n, Err: err}
+			continue
+		}
+
+		responses[i].ClientAddrPort = clientAddrPort
+		answered[i].Store(true)
+		pending--
+	}
+
+	stop()
+	cancel()
+	wg.Wait()
+
+	for i := range responses {
+		switch {
+		case answered[i].Load():
+			responses[i].Err = nil
+		case recvErrs[i] != nil:
+			responses[i].Err = recvErrs[i]
+		case responses[i].Err == nil:
+			responses[i].Err = ErrNoResponse
+		}
+	}
+
+	return newConsensusResult(responses, c.quorum), nil
+}
+
+// Close closes the client socket.
+func (c *ConsensusClient) Close() error {
+	return c.serverConn.Close()
+}
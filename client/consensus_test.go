@@ -0,0 +1,81 @@
+package client
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestConsensusResult(t *testing.T) {
+	serverA := netip.MustParseAddrPort("198.51.100.1:20220")
+	serverB := netip.MustParseAddrPort("198.51.100.2:20220")
+	serverC := netip.MustParseAddrPort("198.51.100.3:20220")
+	addrX := netip.MustParseAddrPort("192.0.2.1:10000")
+	addrY := netip.MustParseAddrPort("192.0.2.1:20000")
+
+	for _, c := range []struct {
+		name                 string
+		responses            []ServerResponse
+		quorum               int
+		wantAddrPort         netip.AddrPort
+		destinationDependent bool
+	}{
+		{
+			name: "Unanimous",
+			responses: []ServerResponse{
+				{ServerAddrPort: serverA, ClientAddrPort: addrX},
+				{ServerAddrPort: serverB, ClientAddrPort: addrX},
+				{ServerAddrPort: serverC, ClientAddrPort: addrX},
+			},
+			quorum:       3,
+			wantAddrPort: addrX,
+		},
+		{
+			name: "Majority",
+			responses: []ServerResponse{
+				{ServerAddrPort: serverA, ClientAddrPort: addrY},
+				{ServerAddrPort: serverB, ClientAddrPort: addrX},
+				{ServerAddrPort: serverC, ClientAddrPort: addrX},
+			},
+			quorum:               2,
+			wantAddrPort:         addrX,
+			destinationDependent: true,
+		},
+		{
+			name: "NotEnoughResponses",
+			responses: []ServerResponse{
+				{ServerAddrPort: serverA, ClientAddrPort: addrX},
+				{ServerAddrPort: serverB, Err: ErrNoResponse},
+				{ServerAddrPort: serverC, Err: ErrNoResponse},
+			},
+			quorum: 2,
+		},
+		{
+			name: "Tie",
+			responses: []ServerResponse{
+				{ServerAddrPort: serverA, ClientAddrPort: addrX},
+				{ServerAddrPort: serverB, ClientAddrPort: addrY},
+				{ServerAddrPort: serverC, Err: ErrNoResponse},
+			},
+			quorum:               1,
+			destinationDependent: true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			r := newConsensusResult(c.responses, c.quorum)
+
+			addrPort, err := r.ClientAddrPort()
+			if c.wantAddrPort.IsValid() {
+				if err != nil || addrPort != c.wantAddrPort {
+					t.Errorf("Got %s, %v, expected %s", addrPort, err, c.wantAddrPort)
+				}
+			} else if !errors.Is(err, ErrNoQuorum) {
+				t.Errorf("Got %s, %v, expected %v", addrPort, err, ErrNoQuorum)
+			}
+
+			if got := r.DestinationDependent(); got != c.destinationDependent {
+				t.Errorf("Got destination-dependent %t, expected %t", got, c.destinationDependent)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/database64128/opdt-go/packet"
+	"github.com/database64128/opdt-go/secret"
+)
+
+var ErrNoServerAddress = errors.New("no server address of this address family")
+
+// DualStackConfig is the configuration of a [DualStackClient].
+type DualStackConfig struct {
+	// IPv4ServerAddrPort and IPv6ServerAddrPort are the server addresses of each family.
+	// A family without a valid server address is reported as failed.
+	IPv4ServerAddrPort netip.AddrPort
+	IPv6ServerAddrPort netip.AddrPort
+
+	// IPv4BindAddress and IPv6BindAddress are the bind addresses of the socket of each family.
+	// If empty, the system chooses.
+	IPv4BindAddress string
+	IPv6BindAddress string
+
+	PSK secret.Key
+
+	// SendLocalAddress controls whether requests carry the client's local address.
+	SendLocalAddress bool
+}
+
+// DualStackClient discovers the client address of both IPv4 and IPv6 in parallel,
+// using a separate socket for each family.
+type DualStackClient struct {
+	ipv4 familyClient
+	ipv6 familyClient
+}
+
+// familyClient is the client of an address family, or the reason it is unavailable.
+type familyClient struct {
+	client *Client
+	err    Error
+}
+
+// DualStackClient returns a new dual-stack client.
+//
+// Failing to bind the socket of a family does not fail the client,
+// and is reported in the results of that family instead.
+func (c DualStackConfig) DualStackClient() (*DualStackClient, error) {
+	if _, err := packet.NewClient(c.PSK); err != nil {
+		return nil, err
+	}
+
+	return &DualStackClient{
+		ipv4: c.familyClient("udp4", c.IPv4ServerAddrPort, c.IPv4BindAddress),
+		ipv6: c.familyClient("udp6", c.IPv6ServerAddrPort, c.IPv6BindAddress),
+	}, nil
+}
+
+func (c DualStackConfig) familyClient(network string, serverAddrPort netip.AddrPort, bindAddress string) familyClient {
+	if !serverAddrPort.IsValid() {
+		return familyClient{err: Error{Message: "no server address", Err: ErrNoServerAddress}}
+	}
+
+	client, err := Config{
+		ServerAddrPort:   serverAddrPort,
+		BindAddress:      bindAddress,
+		PSK:              c.PSK,
+		SendLocalAddress: c.SendLocalAddress,
+	}.client(network)
+	if err != nil {
+		return familyClient{err: Error{Message: "failed to create socket", PeerAddrPort: serverAddrPort, Err: err}}
+	}
+
+	return familyClient{client: client}
+}
+
+// get returns the result of [Client.GetResult], or the reason the family is unavailable.
+func (fc familyClient) get(ctx context.Context, interval time.Duration, attempts int) Result {
+	if fc.client == nil {
+		return ErrResult(fc.err)
+	}
+
+	result, err := fc.client.GetResult(ctx, interval, attempts)
+	if err == nil {
+		return result
+	}
+
+	serverAddrPort := result.ServerAddrPort
+	var clientErr Error
+	if errors.As(err, &clientErr) {
+		result = ErrResult(clientErr)
+	} else {
+		result = ErrResult(Error{Message: "failed to get client address", PeerAddrPort: serverAddrPort, Err: err})
+	}
+	result.ServerAddrPort = serverAddrPort
+	return result
+}
+
+// DualStackResult holds the result of each address family.
+type DualStackResult struct {
+	IPv4 Result
+	IPv6 Result
+}
+
+// IsOk returns whether the client address of at least one family was discovered.
+func (r DualStackResult) IsOk() bool {
+	return r.IPv4.IsOk() || r.IPv6.IsOk()
+}
+
+// Get discovers the client address of both families in parallel, as [Client.Get] does.
+func (c *DualStackClient) Get(ctx context.Context, interval time.Duration, attempts int) DualStackResult {
+	var (
+		r  DualStackResult
+		wg sync.WaitGroup
+	)
+	wg.Go(func() {
+		r.IPv4 = c.ipv4.get(ctx, interval, attempts)
+	})
+	wg.Go(func() {
+		r.IPv6 = c.ipv6.get(ctx, interval, attempts)
+	})
+	wg.Wait()
+	return r
+}
+
+// Close closes the sockets of both families.
+func (c *DualStackClient) Close() error {
+	var errs []error
+	for _, fc := range [...]familyClient{c.ipv4, c.ipv6} {
+		if fc.client != nil {
+			errs = append(errs, fc.client.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
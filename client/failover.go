@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// runWithFailover is like [Client.run], starting with the first server not in a cool-down period,
+// and switching servers as described in [Client.Run].
+// current is updated with the server requests are sent to.
+func (c *Client) runWithFailover(ctx context.Context, interval time.Duration, current *atomic.Pointer[server]) (<-chan Result, error) {
+	s, err := c.firstServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current.Store(s)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	runCh, err := c.run(runCtx, interval, s)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resultCh := make(chan Result)
+
+	go func() {
+		defer close(resultCh)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		failoverAfter := interval * time.Duration(c.failoverAfter)
+		lastOK := time.Now()
+
+		for {
+			select {
+			case result, ok := <-runCh:
+				if !ok {
+					cancel()
+					return
+				}
+				if result.IsOk() {
+					lastOK = time.Now()
+				}
+				resultCh <- result
+
+			case now := <-ticker.C:
+				next := c.nextServer(ctx, now, s, now.Sub(lastOK) >= failoverAfter)
+				if next == s {
+					continue
+				}
+
+				// Wait for the run to stop using the socket before starting the next one.
+				cancel()
+				for result := range runCh {
+					resultCh <- result
+				}
+
+				s = next
+				current.Store(s)
+				lastOK = now
+
+				runCtx, cancel = context.WithCancel(ctx)
+				if runCh, err = c.run(runCtx, interval, s); err != nil {
+					cancel()
+					resultCh <- s.errResult(Error{Message: "failed to switch server", PeerAddrPort: s.AddrPort(), Err: err})
+					return
+				}
+			}
+		}
+	}()
+
+	return resultCh, nil
+}
+
+// nextServer returns the server to send requests to at now, instead of current.
+// If failed, current is put in a cool-down period first.
+//
+// The first server not in a cool-down period is preferred.
+// If all servers are in a cool-down period, a failed server is replaced by the one after it.
+func (c *Client) nextServer(ctx context.Context, now time.Time, current *server, failed bool) *server {
+	if failed {
+		current.markUnhealthy(now.Add(c.cooldown))
+	}
+
+	// On failure, the previously discovered servers are kept.
+	_ = c.discover(ctx)
+
+	servers := c.serverOrder(now)
+	switch {
+	case len(servers) == 0:
+		return current
+	case servers[0].isHealthy(now):
+		return servers[0]
+	case !failed:
+		return current
+	}
+
+	i := slices.Index(servers, current)
+	return servers[(i+1)%len(servers)]
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+const defaultHookTimeout = 30 * time.Second
+
+// Hook is a command run on watch events.
+type Hook struct {
+	// Command is the command line, run by the system shell.
+	Command string
+
+	// Timeout is the maximum time the command may run.
+	// If zero, the command is killed after 30 seconds.
+	Timeout time.Duration
+}
+
+// Run runs the command with the event in its environment, as returned by [Event.Env].
+func (h Hook) Run(ctx context.Context, event Event) error {
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd.exe", "/C", h.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", h.Command)
+	}
+	cmd.Env = append(os.Environ(), event.Env()...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if output = bytes.TrimSpace(output); len(output) > 0 {
+			return fmt.Errorf("%w: %s", err, output)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/database64128/opdt-go/packet"
+	"github.com/database64128/opdt-go/secret"
+)
+
+const (
+	defaultResolveInterval      = 10 * time.Minute
+	defaultResolveAfterFailures = 3
+)
+
+var errNoServer = errors.New("neither server address nor IP address and port configured")
+
+// ServerConfig is the address and PSK of a server.
+type ServerConfig struct {
+	AddrPort netip.AddrPort
+
+	// Address is the server address in the form "host:port", used when AddrPort is not valid.
+	Address string
+
+	PSK secret.Key
+}
+
+// server is a server the client queries, with its resolved address and health state.
+type server struct {
+	// host is the host name of the server, or empty if the server is configured by IP address.
+	host    string
+	port    uint16
+	handler *packet.Client
+
+	mu         sync.Mutex
+	addrPort   netip.AddrPort
+	resolvedAt time.Time
+
+	// unhealthyUntil is the end of the server's cool-down period, in Unix nanoseconds.
+	unhealthyUntil atomic.Int64
+}
+
+// newServer returns a new server at addrPort, or at address if addrPort is not valid.
+func newServer(addrPort netip.AddrPort, address string, handler *packet.Client) (*server, error) {
+	if addrPort.IsValid() {
+		return &server{
+			addrPort: addrPort,
+			handler:  handler,
+		}, nil
+	}
+
+	if address == "" {
+		return nil, errNoServer
+	}
+
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad port %q: %w", portString, err)
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return &server{
+			addrPort: netip.AddrPortFrom(addr, uint16(port)),
+			handler:  handler,
+		}, nil
+	}
+
+	return &server{
+		host:    host,
+		port:    uint16(port),
+		handler: handler,
+	}, nil
+}
+
+// String returns the configured address of the server.
+func (s *server) String() string {
+	if s.host == "" {
+		return s.addrPort.String()
+	}
+	return net.JoinHostPort(s.host, strconv.FormatUint(uint64(s.port), 10))
+}
+
+// AddrPort returns the last resolved address of the server.
+// It returns the zero value if the server has a host name that has not been resolved.
+func (s *server) AddrPort() netip.AddrPort {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addrPort
+}
+
+// errResult returns an error result from the server.
+func (s *server) errResult(err Error) Result {
+	result := ErrResult(err)
+	result.ServerAddrPort = s.AddrPort()
+	return result
+}
+
+// needsResolve returns whether the server's host name is unresolved,
+// or was last resolved at least interval before now.
+func (s *server) needsResolve(now time.Time, interval time.Duration) bool {
+	if s.host == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.addrPort.IsValid() || now.Sub(s.resolvedAt) >= interval
+}
+
+// resolve resolves the server's host name with the given network, "ip", "ip4" or "ip6".
+//
+// The current address is kept if it is still among the resolved addresses,
+// unless next is true, in which case the address after it is used,
+// so that a server that keeps failing is tried at each of its addresses in turn.
+// On failure, the previously resolved address is kept.
+func (s *server) resolve(ctx context.Context, resolver *net.Resolver, network string, next bool) (netip.AddrPort, error) {
+	if s.host == "" {
+		return s.addrPort, nil
+	}
+
+	addrs, err := resolver.LookupNetIP(ctx, network, s.host)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if len(addrs) == 0 {
+		return netip.AddrPort{}, fmt.Errorf("%w: %s", ErrNoServerAddress, s.host)
+	}
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.Index(addrs, s.addrPort.Addr())
+	switch {
+	case i < 0:
+		i = 0
+	case next:
+		i = (i + 1) % len(addrs)
+	}
+
+	s.addrPort = netip.AddrPortFrom(addrs[i], s.port)
+	s.resolvedAt = time.Now()
+	return s.addrPort, nil
+}
+
+// isHealthy returns whether the server is not in a cool-down period at now.
+func (s *server) isHealthy(now time.Time) bool {
+	return now.UnixNano() >= s.unhealthyUntil.Load()
+}
+
+// markUnhealthy puts the server in a cool-down period until the given time.
+func (s *server) markUnhealthy(until time.Time) {
+	s.unhealthyUntil.Store(until.UnixNano())
+}
+
+// markHealthy ends the server's cool-down period.
+func (s *server) markHealthy() {
+	s.unhealthyUntil.Store(0)
+}
+
+// serverOrder returns the servers in the order they should be tried at now:
+// healthy servers in the configured order, followed by servers in a cool-down period.
+func (c *Client) serverOrder(now time.Time) []*server {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	servers := make([]*server, 0, len(c.servers))
+	for _, s := range c.servers {
+		if s.isHealthy(now) {
+			servers = append(servers, s)
+		}
+	}
+	for _, s := range c.servers {
+		if !slices.Contains(servers, s) {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
+// resolveNetwork returns the network to resolve server host names with,
+// so that the resolved addresses are reachable from the client socket.
+func resolveNetwork(network string, localAddrPort netip.AddrPort) string {
+	switch network {
+	case "udp4":
+		return "ip4"
+	case "udp6":
+		return "ip6"
+	}
+	if addr := localAddrPort.Addr(); !addr.IsUnspecified() {
+		if addr.Is4() {
+			return "ip4"
+		}
+		return "ip6"
+	}
+	return "ip"
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// firstServer discovers servers if needed, and returns the first server to try.
+func (c *Client) firstServer(ctx context.Context) (*server, error) {
+	discoverErr := c.discover(ctx)
+	servers := c.serverOrder(time.Now())
+	if len(servers) == 0 {
+		return nil, discoverErr
+	}
+	return servers[0], nil
+}
+
+// discover looks up the SRV records of the SRV domain, if configured and not recently looked up,
+// and replaces the discovered servers. Servers that are still present keep their health state.
+//
+// On failure, the previously discovered servers are kept.
+func (c *Client) discover(ctx context.Context) error {
+	if c.srvDomain == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	fresh := !c.discoveredAt.IsZero() && time.Since(c.discoveredAt) < c.resolveInterval
+	c.mu.Unlock()
+	if fresh {
+		return nil
+	}
+
+	// The records are sorted by priority, and randomized by weight within each priority.
+	_, srvs, err := c.resolver.LookupSRV(ctx, "opdt", "udp", c.srvDomain)
+	if err != nil {
+		return fmt.Errorf("failed to discover servers of %s: %w", c.srvDomain, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous := c.servers[c.staticServerCount:]
+	servers := c.servers[:c.staticServerCount:c.staticServerCount]
+
+	for _, srv := range srvs {
+		// A target of "." means the service is not available at this domain.
+		host := strings.TrimSuffix(srv.Target, ".")
+		if host == "" {
+			continue
+		}
+		address := net.JoinHostPort(host, strconv.FormatUint(uint64(srv.Port), 10))
+
+		var s *server
+		for _, ps := range previous {
+			if ps.String() == address {
+				s = ps
+				break
+			}
+		}
+		if s == nil {
+			if s, err = newServer(netip.AddrPort{}, address, c.handler); err != nil {
+				return err
+			}
+		}
+		servers = append(servers, s)
+	}
+
+	c.servers = servers
+	c.discoveredAt = time.Now()
+	return nil
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/database64128/opdt-go/packet"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// listenTestResolver starts a DNS server on the loopback address that answers
+// SRV queries of srvName with srvs, and A queries with as, or the loopback address if as is empty,
+// and returns a resolver that uses it.
+func listenTestResolver(t *testing.T, srvName string, srvs []dnsmessage.SRVResource, as ...[4]byte) *net.Resolver {
+	t.Helper()
+	if len(as) == 0 {
+		as = [][4]byte{{127, 0, 0, 1}}
+	}
+
+	pc, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		b := make([]byte, 1500)
+		for {
+			n, addrPort, err := pc.ReadFromUDPAddrPort(b)
+			if err != nil {
+				return
+			}
+
+			var p dnsmessage.Parser
+			header, err := p.Start(b[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+
+			builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+				ID:                 header.ID,
+				Response:           true,
+				Authoritative:      true,
+				RecursionDesired:   header.RecursionDesired,
+				RecursionAvailable: true,
+			})
+			builder.EnableCompression()
+			_ = builder.StartQuestions()
+			_ = builder.Question(q)
+			_ = builder.StartAnswers()
+			rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}
+			switch {
+			case q.Type == dnsmessage.TypeSRV && q.Name.String() == srvName:
+				for _, srv := range srvs {
+					_ = builder.SRVResource(rh, srv)
+				}
+			case q.Type == dnsmessage.TypeA:
+				for _, a := range as {
+					_ = builder.AResource(rh, dnsmessage.AResource{A: a})
+				}
+			}
+			msg, err := builder.Finish()
+			if err != nil {
+				continue
+			}
+			_, _ = pc.WriteToUDPAddrPort(msg, addrPort)
+		}
+	}()
+
+	resolverAddr := pc.LocalAddr().String()
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", resolverAddr)
+		},
+	}
+}
+
+func TestClientGetSRV(t *testing.T) {
+	psk := make([]byte, chacha20poly1305.KeySize)
+	rand.Read(psk)
+	handler, err := packet.NewServer(psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loopback := netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	deadAddrPort := listenTestServer(t, loopback, nil)
+	liveAddrPort := listenTestServer(t, loopback, handler)
+
+	resolver := listenTestResolver(t, "_opdt._udp.example.test.", []dnsmessage.SRVResource{
+		{
+			Priority: 20,
+			Weight:   1,
+			Port:     liveAddrPort.Port(),
+			Target:   dnsmessage.MustNewName("live.example.test."),
+		},
+		{
+			Priority: 10,
+			Weight:   1,
+			Port:     deadAddrPort.Port(),
+			Target:   dnsmessage.MustNewName("dead.example.test."),
+		},
+	})
+
+	c, err := Config{
+		SRVDomain:   "example.test",
+		Resolver:    resolver,
+		BindAddress: "127.0.0.1:0",
+		PSK:         psk,
+	}.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	result, err := c.GetResult(context.Background(), 50*time.Millisecond, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ServerAddrPort != liveAddrPort {
+		t.Errorf("Got server address %s, expected %s", result.ServerAddrPort, liveAddrPort)
+	}
+
+	servers := c.serverOrder(time.Now())
+	if len(servers) != 2 {
+		t.Fatalf("Got %d servers, expected 2", len(servers))
+	}
+	if got := servers[0].AddrPort(); got != liveAddrPort {
+		t.Errorf("Got first server %s after failover, expected %s", got, liveAddrPort)
+	}
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"errors"
+	"io/fs"
+	"net/netip"
+	"time"
+
+	"github.com/database64128/opdt-go/jsonhelper"
+)
+
+// State is the last known mapping of a client, as persisted in a state file.
+type State struct {
+	// Server is the configured address of the server the mapping was discovered with.
+	Server string `json:"server,omitempty"`
+
+	// ClientAddress is the last discovered client address.
+	ClientAddress netip.AddrPort `json:"clientAddress,omitzero"`
+
+	// Address and Port are the IP address and port of ClientAddress,
+	// for readers that do not parse address-port pairs.
+	Address netip.Addr `json:"address,omitzero"`
+	Port    uint16     `json:"port,omitempty"`
+
+	// LastConfirmed is when ClientAddress was last confirmed by a response.
+	LastConfirmed time.Time `json:"lastConfirmed,omitzero"`
+
+	// LastError is the error of the most recent failed request, if any.
+	LastError     string    `json:"lastError,omitempty"`
+	LastErrorTime time.Time `json:"lastErrorTime,omitzero"`
+}
+
+// LoadState loads the state from the file at path.
+// If the file does not exist, it returns the zero state.
+func LoadState(path string) (State, error) {
+	var s State
+	if err := jsonhelper.OpenAndDecodeDisallowUnknownFields(path, &s); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return State{}, err
+	}
+	return s, nil
+}
+
+// Save atomically writes the state to the file at path.
+func (s State) Save(path string) error {
+	return jsonhelper.EncodeAndWriteFileAtomic(path, s, 0644)
+}
+
+// Update applies a result received at now to the state.
+// It returns whether the client address or the error message changed.
+func (s *State) Update(result Result, now time.Time) bool {
+	if !result.IsOk() {
+		msg := result.Err.Error()
+		changed := msg != s.LastError
+		s.LastError = msg
+		s.LastErrorTime = now
+		return changed
+	}
+
+	changed := result.ClientAddrPort != s.ClientAddress
+	s.ClientAddress = result.ClientAddrPort
+	s.Address = result.ClientAddrPort.Addr()
+	s.Port = result.ClientAddrPort.Port()
+	s.LastConfirmed = now
+	return changed
+}
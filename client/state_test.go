@@ -0,0 +1,58 @@
+package client
+
+import (
+	"errors"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	state, err := LoadState(path)
+	if err != nil {
+		t.Fatalf("Failed to load missing state file: %v", err)
+	}
+	if state != (State{}) {
+		t.Errorf("Got state %+v from missing file, expected zero state", state)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	addrPort := netip.MustParseAddrPort("192.0.2.1:10000")
+	state.Server = "opdt.example.com:20220"
+
+	if !state.Update(OkResult(addrPort), now) {
+		t.Error("Expected the first address to be a change")
+	}
+	if state.Update(OkResult(addrPort), now.Add(time.Second)) {
+		t.Error("Expected the same address not to be a change")
+	}
+
+	errResult := ErrResult(Error{Message: "test", Err: errors.New("test error")})
+	if !state.Update(errResult, now.Add(2*time.Second)) {
+		t.Error("Expected a new error to be a change")
+	}
+	if state.Update(errResult, now.Add(3*time.Second)) {
+		t.Error("Expected the same error not to be a change")
+	}
+
+	if err = state.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Server != state.Server ||
+		loaded.ClientAddress != addrPort ||
+		loaded.Address != addrPort.Addr() ||
+		loaded.Port != addrPort.Port() ||
+		!loaded.LastConfirmed.Equal(now.Add(time.Second)) ||
+		loaded.LastError != state.LastError ||
+		!loaded.LastErrorTime.Equal(now.Add(3*time.Second)) {
+		t.Errorf("Got state %+v, expected %+v", loaded, state)
+	}
+}
@@ -0,0 +1,242 @@
+package client
+
+import (
+	"context"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const defaultWatchFailureThreshold = 3
+
+// EventType is the type of a watch event.
+type EventType string
+
+const (
+	// EventChanged is emitted when the client address changes,
+	// including when it is discovered for the first time.
+	EventChanged EventType = "changed"
+
+	// EventDown is emitted when no response has been received for a number of consecutive intervals.
+	EventDown EventType = "down"
+
+	// EventUp is emitted when a response is received after the path went down.
+	EventUp EventType = "up"
+)
+
+// Event is a change observed in watch mode.
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	// ServerAddrPort is the address of the server that was queried.
+	ServerAddrPort netip.AddrPort
+
+	// OldAddrPort is the client address before the event.
+	// It is invalid if the address had not been discovered.
+	OldAddrPort netip.AddrPort
+
+	// NewAddrPort is the client address after the event.
+	// It is invalid for [EventDown].
+	NewAddrPort netip.AddrPort
+
+	// Failures is the number of consecutive intervals without a response.
+	// It is only set for [EventDown].
+	Failures int
+
+	// Err is the last error received before the path went down, if any.
+	// It is only set for [EventDown].
+	Err error
+}
+
+// Env returns the event as environment variables in the form "key=value".
+func (e Event) Env() []string {
+	env := []string{
+		"OPDT_EVENT=" + string(e.Type),
+		"OPDT_TIME=" + e.Time.Format(time.RFC3339),
+		"OPDT_SERVER=" + addrPortString(e.ServerAddrPort),
+		"OPDT_OLD_ADDR=" + addrString(e.OldAddrPort),
+		"OPDT_OLD_PORT=" + portString(e.OldAddrPort),
+		"OPDT_NEW_ADDR=" + addrString(e.NewAddrPort),
+		"OPDT_NEW_PORT=" + portString(e.NewAddrPort),
+		"OPDT_FAILURES=" + strconv.Itoa(e.Failures),
+	}
+	if e.Err != nil {
+		env = append(env, "OPDT_ERROR="+e.Err.Error())
+	}
+	return env
+}
+
+// addrPortString returns addrPort as ip:port, or an empty string if addrPort is invalid.
+func addrPortString(addrPort netip.AddrPort) string {
+	if !addrPort.IsValid() {
+		return ""
+	}
+	return addrPort.String()
+}
+
+// addrString returns the IP address of addrPort, or an empty string if addrPort is invalid.
+func addrString(addrPort netip.AddrPort) string {
+	if !addrPort.IsValid() {
+		return ""
+	}
+	return addrPort.Addr().String()
+}
+
+// portString returns the port of addrPort, or an empty string if addrPort is invalid.
+func portString(addrPort netip.AddrPort) string {
+	if !addrPort.IsValid() {
+		return ""
+	}
+	return strconv.FormatUint(uint64(addrPort.Port()), 10)
+}
+
+// WatchConfig is the configuration of watch mode.
+type WatchConfig struct {
+	// Interval is the interval between requests.
+	// If zero, the default interval is used.
+	Interval time.Duration
+
+	// FailureThreshold is the number of consecutive intervals without a response
+	// after which the path is considered down.
+	// If zero, the path is considered down after 3 intervals.
+	FailureThreshold int
+
+	// InitialAddrPort is the last known client address, for example from a previous run.
+	// If valid, discovering the same address does not emit an event.
+	InitialAddrPort netip.AddrPort
+
+	// OnResult, if not nil, is called with each result before it is turned into events.
+	// It is called on the watch goroutine, and must not block for long.
+	OnResult func(Result)
+}
+
+// Watch sends requests at the configured interval, and returns a channel that receives
+// an event when the client address changes, or when the path goes down or recovers.
+// Like [Client.Run], it switches to the next server when the server stops responding.
+//
+// The channel is closed when ctx is canceled.
+func (c *Client) Watch(ctx context.Context, config WatchConfig) (<-chan Event, error) {
+	interval := config.Interval
+	if interval == 0 {
+		interval = defaultInterval
+	}
+
+	var current atomic.Pointer[server]
+	resultCh, err := c.runWithFailover(ctx, interval, &current)
+	if err != nil {
+		return nil, err
+	}
+
+	w := newWatcher(interval, config.FailureThreshold, config.InitialAddrPort, time.Now())
+	eventCh := make(chan Event, 1)
+
+	go func() {
+		defer close(eventCh)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var events []Event
+
+		for {
+			select {
+			case result, ok := <-resultCh:
+				if !ok {
+					return
+				}
+				if config.OnResult != nil {
+					config.OnResult(result)
+				}
+				events = w.result(result, time.Now())
+			case now := <-ticker.C:
+				events = w.tick(now)
+			}
+
+			for _, event := range events {
+				event.ServerAddrPort = current.Load().AddrPort()
+				eventCh <- event
+			}
+		}
+	}()
+
+	return eventCh, nil
+}
+
+// watcher turns results into events.
+type watcher struct {
+	interval         time.Duration
+	failureThreshold int
+	addrPort         netip.AddrPort
+	lastOK           time.Time
+	lastErr          error
+	down             bool
+}
+
+func newWatcher(interval time.Duration, failureThreshold int, initialAddrPort netip.AddrPort, now time.Time) *watcher {
+	if failureThreshold <= 0 {
+		failureThreshold = defaultWatchFailureThreshold
+	}
+	return &watcher{
+		interval:         interval,
+		failureThreshold: failureThreshold,
+		addrPort:         initialAddrPort,
+		lastOK:           now,
+	}
+}
+
+// result processes a result received at now, and returns the resulting events.
+func (w *watcher) result(result Result, now time.Time) []Event {
+	if !result.IsOk() {
+		w.lastErr = result.Err
+		return nil
+	}
+
+	var events []Event
+	w.lastOK = now
+	w.lastErr = nil
+
+	if w.down {
+		w.down = false
+		events = append(events, Event{
+			Type:        EventUp,
+			Time:        now,
+			OldAddrPort: w.addrPort,
+			NewAddrPort: result.ClientAddrPort,
+		})
+	}
+
+	if result.ClientAddrPort != w.addrPort {
+		events = append(events, Event{
+			Type:        EventChanged,
+			Time:        now,
+			OldAddrPort: w.addrPort,
+			NewAddrPort: result.ClientAddrPort,
+		})
+		w.addrPort = result.ClientAddrPort
+	}
+
+	return events
+}
+
+// tick checks at now whether the path went down, and returns the resulting events.
+func (w *watcher) tick(now time.Time) []Event {
+	if w.down {
+		return nil
+	}
+
+	failures := int(now.Sub(w.lastOK) / w.interval)
+	if failures < w.failureThreshold {
+		return nil
+	}
+
+	w.down = true
+	return []Event{{
+		Type:        EventDown,
+		Time:        now,
+		OldAddrPort: w.addrPort,
+		Failures:    failures,
+		Err:         w.lastErr,
+	}}
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	const interval = time.Second
+	start := time.Now()
+	addrA := netip.MustParseAddrPort("192.0.2.1:10000")
+	addrB := netip.MustParseAddrPort("192.0.2.1:20000")
+
+	w := newWatcher(interval, 3, addrA, start)
+
+	// Same as the initial address: no event.
+	if events := w.result(OkResult(addrA), start); len(events) != 0 {
+		t.Errorf("Got events %v for the initial address, expected none", events)
+	}
+
+	if events := w.result(OkResult(addrB), start.Add(interval)); len(events) != 1 ||
+		events[0].Type != EventChanged || events[0].OldAddrPort != addrA || events[0].NewAddrPort != addrB {
+		t.Errorf("Got events %v, expected a change from %s to %s", events, addrA, addrB)
+	}
+
+	// Errors alone do not emit events.
+	errTest := errors.New("test error")
+	if events := w.result(ErrResult(Error{Message: "test", Err: errTest}), start.Add(2*interval)); len(events) != 0 {
+		t.Errorf("Got events %v for an error result, expected none", events)
+	}
+
+	if events := w.tick(start.Add(3 * interval)); len(events) != 0 {
+		t.Errorf("Got events %v before the failure threshold, expected none", events)
+	}
+
+	events := w.tick(start.Add(4 * interval))
+	if len(events) != 1 || events[0].Type != EventDown || events[0].Failures != 3 || !errors.Is(events[0].Err, errTest) {
+		t.Fatalf("Got events %v, expected the path to go down after 3 failures", events)
+	}
+
+	// Down is only reported once.
+	if events := w.tick(start.Add(10 * interval)); len(events) != 0 {
+		t.Errorf("Got events %v while down, expected none", events)
+	}
+
+	events = w.result(OkResult(addrA), start.Add(11*interval))
+	if len(events) != 2 || events[0].Type != EventUp || events[1].Type != EventChanged ||
+		events[1].OldAddrPort != addrB || events[1].NewAddrPort != addrA {
+		t.Errorf("Got events %v, expected the path to recover with a change from %s to %s", events, addrB, addrA)
+	}
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultWebhookMaxAttempts    = 5
+	defaultWebhookTimeout        = 10 * time.Second
+	defaultWebhookInitialBackoff = time.Second
+	maxWebhookBackoff            = time.Minute
+)
+
+const (
+	// WebhookTimestampHeader is the header that carries the Unix time at which a delivery was signed.
+	WebhookTimestampHeader = "X-Opdt-Timestamp"
+
+	// WebhookSignatureHeader is the header that carries the signature of a delivery, in the form
+	// "sha256=<hex>", where <hex> is the HMAC-SHA256 of the timestamp, a dot, and the request body.
+	WebhookSignatureHeader = "X-Opdt-Signature"
+)
+
+// WebhookPayload is the JSON body posted to webhooks.
+type WebhookPayload struct {
+	Event      EventType      `json:"event"`
+	Time       time.Time      `json:"time"`
+	ClientName string         `json:"client"`
+	Server     netip.AddrPort `json:"server,omitzero"`
+	OldAddress netip.AddrPort `json:"oldAddress,omitzero"`
+	NewAddress netip.AddrPort `json:"newAddress,omitzero"`
+	Failures   int            `json:"failures,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// NewWebhookPayload returns the webhook payload of the event, sent by the named client.
+func NewWebhookPayload(event Event, clientName string) WebhookPayload {
+	p := WebhookPayload{
+		Event:      event.Type,
+		Time:       event.Time,
+		ClientName: clientName,
+		Server:     event.ServerAddrPort,
+		OldAddress: event.OldAddrPort,
+		NewAddress: event.NewAddrPort,
+		Failures:   event.Failures,
+	}
+	if event.Err != nil {
+		p.Error = event.Err.Error()
+	}
+	return p
+}
+
+// Webhook is an HTTP endpoint notified of watch events.
+type Webhook struct {
+	// URL is the URL the payload is posted to.
+	URL string
+
+	// Secret is the key used to sign deliveries.
+	// If empty, deliveries are not signed.
+	Secret []byte
+
+	// Events is the list of event types delivered to the webhook.
+	// If empty, only [EventChanged] is delivered.
+	Events []EventType
+
+	// MaxAttempts is the maximum number of delivery attempts.
+	// If zero, a delivery is attempted up to 5 times.
+	MaxAttempts int
+
+	// Timeout is the timeout of each delivery attempt.
+	// If zero, each attempt times out after 10 seconds.
+	Timeout time.Duration
+
+	// HTTPClient is the client used to send requests.
+	// If nil, [http.DefaultClient] is used.
+	HTTPClient *http.Client
+}
+
+// Wants returns whether the webhook is notified of events of the given type.
+func (w *Webhook) Wants(eventType EventType) bool {
+	if len(w.Events) == 0 {
+		return eventType == EventChanged
+	}
+	return slices.Contains(w.Events, eventType)
+}
+
+// Sign returns the signature of a delivery of body signed at timestamp.
+func (w *Webhook) Sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, w.Secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// errPermanent marks a delivery error that is not worth retrying.
+var errPermanent = errors.New("permanent webhook delivery failure")
+
+// Deliver posts the payload to the webhook, retrying with exponential backoff
+// on network errors, rate limiting and server errors.
+func (w *Webhook) Deliver(ctx context.Context, payload WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	maxAttempts := w.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultWebhookMaxAttempts
+	}
+	backoff := defaultWebhookInitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err = w.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, errPermanent) || attempt >= maxAttempts {
+			return fmt.Errorf("failed to deliver webhook after %d attempts: %w", attempt, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to deliver webhook after %d attempts: %w", attempt, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxWebhookBackoff)
+	}
+}
+
+// post makes a single delivery attempt.
+func (w *Webhook) post(ctx context.Context, body []byte) error {
+	timeout := w.Timeout
+	if timeout == 0 {
+		timeout = defaultWebhookTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.Secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, w.Sign(timestamp, body))
+	}
+
+	httpClient := w.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	default:
+		return fmt.Errorf("%w: unexpected status: %s", errPermanent, resp.Status)
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookDeliver(t *testing.T) {
+	webhook := Webhook{
+		Secret:      []byte("test secret"),
+		MaxAttempts: 2,
+	}
+
+	var attempts atomic.Int32
+	var got WebhookPayload
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if sig := webhook.Sign(r.Header.Get(WebhookTimestampHeader), body); r.Header.Get(WebhookSignatureHeader) != sig {
+			t.Errorf("Got signature %q, expected %q", r.Header.Get(WebhookSignatureHeader), sig)
+		}
+
+		// Fail the first attempt to exercise retries.
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+	webhook.URL = srv.URL
+
+	event := Event{
+		Type:        EventChanged,
+		Time:        time.Now().Truncate(time.Second),
+		OldAddrPort: netip.MustParseAddrPort("192.0.2.1:10000"),
+		NewAddrPort: netip.MustParseAddrPort("192.0.2.1:20000"),
+	}
+	payload := NewWebhookPayload(event, "branch-1")
+
+	if err := webhook.Deliver(context.Background(), payload); err != nil {
+		t.Fatal(err)
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("Got %d attempts, expected 2", n)
+	}
+	if got.ClientName != "branch-1" || got.OldAddress != event.OldAddrPort || got.NewAddress != event.NewAddrPort || !got.Time.Equal(event.Time) {
+		t.Errorf("Got payload %+v, expected %+v", got, payload)
+	}
+
+	// Client errors are not retried.
+	attempts.Store(0)
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	})
+	if err := webhook.Deliver(context.Background(), payload); err == nil {
+		t.Error("Expected delivery to fail")
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Errorf("Got %d attempts, expected 1", n)
+	}
+}
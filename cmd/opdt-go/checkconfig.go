@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/database64128/opdt-go/validate"
+	"go.uber.org/zap"
+)
+
+func runCheckConfigCommand(name string, args []string) {
+	var (
+		serverConfPath string
+		clientConfPath string
+		strict         bool
+	)
+
+	fs := newFlagSet(name, "[-server <path>] [-client <path>]", "Check that the config files are valid, including their keys, without starting anything.\nEach problem is reported with the path of the setting, such as servers[0].listen.\nThe exit status is non-zero if any error is found.")
+	fs.StringVar(&serverConfPath, "server", "", "Path to the server config file to check")
+	fs.StringVar(&clientConfPath, "client", "", "Path to the client config file to check")
+	fs.BoolVar(&strict, "strict", false, "Treat warnings about risky settings as errors")
+	_ = fs.Parse(args)
+
+	if serverConfPath == "" && clientConfPath == "" {
+		fmt.Fprintln(fs.Output(), "At least one of -server <path> or -client <path> must be specified.")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ok := true
+
+	if serverConfPath != "" {
+		if !writeProblems(os.Stdout, serverConfPath, checkServerConfig(serverConfPath), strict) {
+			ok = false
+		}
+	}
+
+	if clientConfPath != "" {
+		if !writeProblems(os.Stdout, clientConfPath, checkClientConfig(clientConfPath), strict) {
+			ok = false
+		}
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// checkServerConfig loads the server config file at path, and returns the problems found.
+func checkServerConfig(path string) validate.Problems {
+	sc, legacy, err := loadServerConfig(path)
+	if err != nil {
+		return validate.Problems{problemOf(err)}
+	}
+
+	ps := sc.Validate()
+
+	var secretPaths []string
+	if legacy {
+		// Report problems of the converted server at the top-level settings it came from.
+		for i := range ps {
+			switch path := ps[i].Path; {
+			case path == "servers[0]":
+				ps[i].Path = ""
+			case strings.HasPrefix(path, "servers[0].keys[0]."):
+				ps[i].Path = strings.TrimPrefix(path, "servers[0].keys[0].")
+			default:
+				ps[i].Path = strings.TrimPrefix(path, "servers[0].")
+			}
+		}
+		ps.Warnf("listen", `deprecated single-server format, move "listen" and "psk" into "servers": [{"listen": ..., "keys": [{"psk": ...}]}]`)
+		if len(sc.Servers[0].Keys[0].PSK) > 0 {
+			secretPaths = append(secretPaths, "psk")
+		}
+	} else {
+		for i := range sc.Servers {
+			for j, kc := range sc.Servers[i].Keys {
+				keyPath := validate.Index(validate.Field(validate.Index("servers", i), "keys"), j)
+				switch {
+				case len(kc.PSK) > 0:
+					secretPaths = append(secretPaths, validate.Field(keyPath, "psk"))
+				case kc.Argon2id != nil && kc.Argon2id.Passphrase != "":
+					secretPaths = append(secretPaths, validate.Field(keyPath, "argon2id.passphrase"))
+				}
+			}
+		}
+	}
+	warnReadableSecrets(&ps, path, secretPaths)
+
+	return ps
+}
+
+// checkClientConfig loads the client config file at path, and returns the problems found.
+func checkClientConfig(path string) validate.Problems {
+	cc, err := loadClientConfig(path)
+	if err != nil {
+		return validate.Problems{problemOf(err)}
+	}
+
+	ps := cc.validate()
+
+	var secretPaths []string
+	addSecretPaths := func(path string, psk []byte, argon2idPassphrase bool) {
+		switch {
+		case len(psk) > 0:
+			secretPaths = append(secretPaths, validate.Field(path, "psk"))
+		case argon2idPassphrase:
+			secretPaths = append(secretPaths, validate.Field(path, "argon2id.passphrase"))
+		}
+	}
+	addSecretPaths("", cc.PSK, cc.Argon2id != nil && cc.Argon2id.Passphrase != "")
+	for i, sc := range cc.FallbackServers {
+		addSecretPaths(validate.Index("fallbackServers", i), sc.PSK, sc.Argon2id != nil && sc.Argon2id.Passphrase != "")
+	}
+	for i, sc := range cc.ConsensusServers {
+		addSecretPaths(validate.Index("consensusServers", i), sc.PSK, sc.Argon2id != nil && sc.Argon2id.Passphrase != "")
+	}
+	for i, w := range cc.Webhooks {
+		if w.Secret != "" {
+			secretPaths = append(secretPaths, validate.Field(validate.Index("webhooks", i), "secret"))
+		}
+	}
+	warnReadableSecrets(&ps, path, secretPaths)
+
+	return ps
+}
+
+// problemOf returns err as a problem, keeping its path if it is one.
+func problemOf(err error) validate.Problem {
+	var p validate.Problem
+	if errors.As(err, &p) {
+		return p
+	}
+	return validate.Problem{Err: err}
+}
+
+// warnReadableSecrets adds a warning for each inline secret at secretPaths
+// if the config file at path is readable by others.
+func warnReadableSecrets(ps *validate.Problems, path string, secretPaths []string) {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode().Perm()&0o004 == 0 {
+		return
+	}
+	for _, p := range secretPaths {
+		ps.Warnf(p, "inline secret in a config file readable by others, use a file or systemd credential instead")
+	}
+}
+
+// writeProblems writes the problems found in the config file at path to w,
+// or that the file is ok if there are none.
+// It returns whether the file passes, that is, has no errors, and no warnings if strict.
+func writeProblems(w io.Writer, path string, ps validate.Problems, strict bool) bool {
+	if len(ps) == 0 {
+		fmt.Fprintf(w, "%s: ok\n", path)
+		return true
+	}
+	for _, p := range ps {
+		if p.Warning {
+			fmt.Fprintf(w, "%s: warning: %v\n", path, p)
+		} else {
+			fmt.Fprintf(w, "%s: error: %v\n", path, p)
+		}
+	}
+	return !ps.HasErrors() && !strict
+}
+
+// logProblems logs the problems found in the config of kind, "server" or "client".
+// It returns whether there are no errors.
+func logProblems(logger *zap.Logger, kind string, ps validate.Problems) bool {
+	for _, p := range ps {
+		if p.Warning {
+			logger.Warn("Risky "+kind+" config", zap.String("setting", p.Path), zap.Error(p.Err))
+		} else {
+			logger.Error("Invalid "+kind+" config", zap.String("setting", p.Path), zap.Error(p.Err))
+		}
+	}
+	return !ps.HasErrors()
+}
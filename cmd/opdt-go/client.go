@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/database64128/opdt-go/client"
+	"go.uber.org/zap"
+)
+
+func runClientCommand(name string, args []string) {
+	var (
+		f  clientFlags
+		lf loggingFlags
+	)
+
+	fs := newFlagSet(name, "[flags]", "Discover the client address with the servers of -server, -srv or -dualStack, or of the config file of -conf.")
+	f.register(fs)
+	lf.register(fs)
+	_ = fs.Parse(args)
+
+	var modes int
+	for _, s := range [...]string{f.confPath, f.server + f.srvDomain, f.dualStack} {
+		if s != "" {
+			modes++
+		}
+	}
+	if modes != 1 {
+		fmt.Fprintln(fs.Output(), "Exactly one of -conf <path>, -server <address> (or -srv <domain>) or -dualStack <host:port> must be specified.")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	logger, _ := lf.newLogger()
+	defer logger.Sync()
+
+	var (
+		cc  clientConfig
+		err error
+	)
+	if f.confPath != "" {
+		cc, err = loadClientConfig(f.confPath)
+	} else {
+		cc, err = f.clientConfig()
+	}
+	if err == nil {
+		err = cc.loadSecrets()
+	}
+	if err != nil {
+		logger.Fatal("Failed to load client config",
+			zap.String("path", f.confPath),
+			zap.Error(err),
+		)
+	}
+
+	if !logProblems(logger, "client", cc.validate()) {
+		logger.Fatal("Invalid client config", zap.String("path", f.confPath))
+	}
+
+	if f.fingerprint {
+		if err = writeClientFingerprints(os.Stdout, &cc); err != nil {
+			logger.Fatal("Failed to write fingerprints", zap.Error(err))
+		}
+		return
+	}
+
+	runClient(signalContext(), cc, logger)
+}
+
+// runClient runs the client with the configuration.
+func runClient(ctx context.Context, cc clientConfig, logger *zap.Logger) {
+	w := resultWriter{
+		format: cc.Output,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		logger: logger,
+	}
+
+	mode := cc.Schedule.mode()
+	interval := time.Duration(cc.Schedule.Interval)
+	attempts := cc.Schedule.attempts()
+
+	if cc.DualStack {
+		ipv4, ipv6, err := resolveDualStack(ctx, cc.Server)
+		if err != nil {
+			logger.Fatal("Failed to resolve server address",
+				zap.String("server", cc.Server),
+				zap.Error(err),
+			)
+		}
+
+		bind4, bind6, err := dualStackBindAddresses(cc.BindAddress)
+		if err != nil {
+			logger.Fatal("Failed to parse bind address",
+				zap.String("bindAddress", cc.BindAddress),
+				zap.Error(err),
+			)
+		}
+
+		dc, err := client.DualStackConfig{
+			IPv4ServerAddrPort: ipv4,
+			IPv6ServerAddrPort: ipv6,
+			IPv4BindAddress:    bind4,
+			IPv6BindAddress:    bind6,
+			PSK:                cc.PSK,
+			SendLocalAddress:   cc.SendLocalAddress,
+		}.DualStackClient()
+		if err != nil {
+			logger.Fatal("Failed to initialize client", zap.Error(err))
+		}
+
+		result := dc.Get(ctx, interval, attempts)
+		if err = w.WriteDualStack(result); err != nil {
+			logger.Fatal("Failed to write result", zap.Error(err))
+		}
+		if !result.IsOk() {
+			logger.Sync()
+			os.Exit(1)
+		}
+		return
+	}
+
+	var (
+		sw  *stateWriter
+		err error
+	)
+	if cc.StateFile != "" {
+		if sw, err = newStateWriter(cc.StateFile, cc.stateServer(), logger); err != nil {
+			logger.Fatal("Failed to load state file",
+				zap.String("path", cc.StateFile),
+				zap.Error(err),
+			)
+		}
+	}
+
+	var dq *dnsUpdateQueue
+	if cc.DNSUpdate != nil {
+		updater, err := cc.DNSUpdate.Updater()
+		if err != nil {
+			logger.Fatal("Failed to initialize DNS updater", zap.Error(err))
+		}
+		dq = newDNSUpdateQueue(ctx, updater, logger)
+	}
+
+	if len(cc.ConsensusServers) > 0 {
+		servers := make([]client.ServerConfig, 0, 1+len(cc.ConsensusServers))
+		for _, s := range append([]clientServerConfig{{Address: cc.Server}}, cc.ConsensusServers...) {
+			sc, err := resolveServerConfig(ctx, s.serverConfig(cc.PSK))
+			if err != nil {
+				logger.Fatal("Failed to resolve consensus server",
+					zap.String("server", s.Address),
+					zap.Error(err),
+				)
+			}
+			servers = append(servers, sc)
+		}
+
+		consensusClient, err := client.ConsensusConfig{
+			Servers:     servers,
+			BindAddress: cc.BindAddress,
+			Quorum:      cc.Quorum,
+		}.ConsensusClient()
+		if err != nil {
+			logger.Fatal("Failed to initialize consensus client",
+				zap.String("bindAddress", cc.BindAddress),
+				zap.Error(err),
+			)
+		}
+
+		writeOneShotResult(&w, sw, dq, queryConsensus(ctx, consensusClient, interval, attempts, logger), logger)
+		return
+	}
+
+	c, err := cc.clientConfig().Client()
+	if err != nil {
+		logger.Fatal("Failed to initialize client",
+			zap.String("serverAddress", cc.Server),
+			zap.String("srvDomain", cc.SRVDomain),
+			zap.String("bindAddress", cc.BindAddress),
+			zap.Stringer("psk", cc.PSK),
+			zap.Error(err),
+		)
+	}
+
+	switch mode {
+	case scheduleWatch:
+		watchConfig := client.WatchConfig{
+			Interval:         interval,
+			FailureThreshold: cc.Schedule.WatchFailures,
+		}
+		if sw != nil {
+			watchConfig.InitialAddrPort = sw.state.ClientAddress
+		}
+		watchConfig.OnResult = func(result client.Result) {
+			if sw != nil {
+				sw.Write(result)
+			}
+			if dq != nil {
+				dq.Write(result)
+			}
+		}
+
+		eventCh, err := c.Watch(ctx, watchConfig)
+		if err != nil {
+			logger.Fatal("Failed to start client",
+				zap.String("serverAddress", cc.Server),
+				zap.String("srvDomain", cc.SRVDomain),
+				zap.String("bindAddress", cc.BindAddress),
+				zap.Stringer("psk", cc.PSK),
+				zap.Error(err),
+			)
+		}
+
+		name := cc.Name
+		if name == "" {
+			name, _ = os.Hostname()
+		}
+
+		h := newEventHandler(ctx, &w, cc.hooks(), cc.webhooks(), name, logger)
+
+		for event := range eventCh {
+			if err = h.Handle(event); err != nil {
+				logger.Fatal("Failed to write event", zap.Error(err))
+			}
+		}
+
+		h.Close()
+		if dq != nil {
+			dq.Close()
+		}
+
+	case scheduleContinuous:
+		resultCh, err := c.Run(ctx, interval)
+		if err != nil {
+			logger.Fatal("Failed to start client",
+				zap.String("serverAddress", cc.Server),
+				zap.String("srvDomain", cc.SRVDomain),
+				zap.String("bindAddress", cc.BindAddress),
+				zap.Stringer("psk", cc.PSK),
+				zap.Error(err),
+			)
+		}
+
+		for result := range resultCh {
+			if sw != nil {
+				sw.Write(result)
+			}
+			if dq != nil {
+				dq.Write(result)
+			}
+			if err = w.Write(result); err != nil {
+				logger.Fatal("Failed to write result", zap.Error(err))
+			}
+		}
+
+		if dq != nil {
+			dq.Close()
+		}
+
+	default:
+		writeOneShotResult(&w, sw, dq, resultFromGet(c.GetResult(ctx, interval, attempts)), logger)
+	}
+}
+
+// writeOneShotResult writes the result of a one-shot query, updates DNS records if it succeeded,
+// and exits with a non-zero status if it failed.
+func writeOneShotResult(w *resultWriter, sw *stateWriter, dq *dnsUpdateQueue, result client.Result, logger *zap.Logger) {
+	if sw != nil {
+		sw.Write(result)
+	}
+	if err := w.Write(result); err != nil {
+		logger.Fatal("Failed to write result", zap.Error(err))
+	}
+	if dq != nil {
+		dq.Write(result)
+		dq.Close()
+	}
+	if !result.IsOk() {
+		logger.Sync()
+		os.Exit(1)
+	}
+}
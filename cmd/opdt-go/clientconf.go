@@ -0,0 +1,343 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/database64128/opdt-go/client"
+	"github.com/database64128/opdt-go/dnsupdate"
+	"github.com/database64128/opdt-go/jsonhelper"
+	"github.com/database64128/opdt-go/secret"
+	"github.com/database64128/opdt-go/validate"
+)
+
+// defaultClientAttempts is the number of attempts in one-shot mode when not configured.
+const defaultClientAttempts = 5
+
+// pskEnv is the environment variable of the base64-encoded client PSK,
+// used when no PSK is configured.
+const pskEnv = "OPDT_PSK"
+
+// clientConfig is the configuration of the client command,
+// loaded from the JSON file of -conf, or built from the client flags.
+type clientConfig struct {
+	// Server is the address of the server, in the form "host:port".
+	Server string `json:"server,omitempty"`
+
+	// DualStack controls whether the client addresses of both IPv4 and IPv6 are discovered in parallel,
+	// using the A and AAAA addresses of Server.
+	// It only supports the once schedule mode, and cannot be combined with a state file or DNS updates.
+	DualStack bool `json:"dualStack,omitempty"`
+
+	// SRVDomain is the domain to discover servers from, using the SRV records of "_opdt._udp.<domain>".
+	SRVDomain string `json:"srvDomain,omitempty"`
+
+	// PSK is the pre-shared key of Server, and the default of the other servers.
+	// If none of PSK, PSKFile and Argon2id is configured, the key in $OPDT_PSK is used.
+	PSK secret.Key `json:"psk,omitempty"`
+
+	// PSKFile is the path to a file containing the base64-encoded PSK, used instead of PSK.
+	// A bare file name is looked up in $CREDENTIALS_DIRECTORY if set.
+	PSKFile string `json:"pskFile,omitempty"`
+
+	// Argon2id derives the PSK from a passphrase, used instead of PSK.
+	Argon2id *secret.Argon2idConfig `json:"argon2id,omitempty"`
+
+	// FallbackServers are tried in order when the previous server fails.
+	FallbackServers []clientServerConfig `json:"fallbackServers,omitempty"`
+
+	// ConsensusServers are queried in addition to Server in one-shot mode.
+	ConsensusServers []clientServerConfig `json:"consensusServers,omitempty"`
+
+	// Quorum is the number of servers that must agree when ConsensusServers is not empty.
+	// If zero, a majority is required.
+	Quorum int `json:"quorum,omitempty"`
+
+	// BindAddress is the bind address of the client socket.
+	// If empty, the system chooses.
+	BindAddress string `json:"bind,omitempty"`
+
+	// SendLocalAddress controls whether requests carry the client's local address.
+	SendLocalAddress bool `json:"sendLocalAddress,omitempty"`
+
+	// ResolveInterval is the interval between resolutions of server host names and SRV records.
+	// If zero, they are resolved every 10 minutes.
+	ResolveInterval jsonhelper.Duration `json:"resolveInterval,omitempty"`
+
+	// Cooldown is how long a failed server is skipped in favor of the next one.
+	// If zero, failed servers are skipped for 5 minutes.
+	Cooldown jsonhelper.Duration `json:"cooldown,omitempty"`
+
+	// Schedule controls when requests are sent.
+	Schedule clientScheduleConfig `json:"schedule,omitzero"`
+
+	// Output is the format of results. If empty, results are logged.
+	Output outputFormat `json:"output,omitempty"`
+
+	// StateFile is the path to the client state file. If empty, no state file is kept.
+	StateFile string `json:"stateFile,omitempty"`
+
+	// Name is the name of this client in webhook payloads. If empty, the hostname is used.
+	Name string `json:"name,omitempty"`
+
+	// Hooks are the commands run on each event in watch mode.
+	Hooks []clientHookConfig `json:"hooks,omitempty"`
+
+	// Webhooks are the endpoints notified of events in watch mode.
+	Webhooks []clientWebhookConfig `json:"webhooks,omitempty"`
+
+	// DNSUpdate is the configuration of DNS updates.
+	// Records are updated on the first successful result, and then whenever the client address changes.
+	// If nil, DNS records are not updated.
+	DNSUpdate *dnsupdate.Config `json:"dnsUpdate,omitempty"`
+}
+
+// clientServerConfig is the address and PSK of an additional server.
+type clientServerConfig struct {
+	// Address is the address of the server, in the form "host:port".
+	Address string `json:"address"`
+
+	// PSK is the pre-shared key of the server.
+	// If none of PSK, PSKFile and Argon2id is configured, the top-level PSK is used.
+	PSK secret.Key `json:"psk,omitempty"`
+
+	// PSKFile is the path to a file containing the base64-encoded PSK, used instead of PSK.
+	PSKFile string `json:"pskFile,omitempty"`
+
+	// Argon2id derives the PSK from a passphrase, used instead of PSK.
+	Argon2id *secret.Argon2idConfig `json:"argon2id,omitempty"`
+}
+
+// serverConfig returns the client configuration of the server.
+func (c clientServerConfig) serverConfig(defaultPSK secret.Key) client.ServerConfig {
+	sc := client.ServerConfig{
+		PSK: c.PSK,
+	}
+	if len(sc.PSK) == 0 {
+		sc.PSK = defaultPSK
+	}
+	if addrPort, err := netip.ParseAddrPort(c.Address); err == nil {
+		sc.AddrPort = addrPort
+	} else {
+		sc.Address = c.Address
+	}
+	return sc
+}
+
+// scheduleMode is the mode of sending requests in client mode.
+type scheduleMode string
+
+const (
+	// scheduleOnce sends up to the configured number of attempts, and exits after the first result.
+	scheduleOnce scheduleMode = "once"
+
+	// scheduleContinuous keeps sending at the configured interval, and reports every result.
+	scheduleContinuous scheduleMode = "continuous"
+
+	// scheduleWatch keeps sending at the configured interval, and only reports events.
+	scheduleWatch scheduleMode = "watch"
+)
+
+// MarshalText implements [encoding.TextMarshaler].
+func (m scheduleMode) MarshalText() ([]byte, error) {
+	return []byte(m), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler].
+func (m *scheduleMode) UnmarshalText(text []byte) error {
+	switch mm := scheduleMode(text); mm {
+	case scheduleOnce, scheduleContinuous, scheduleWatch:
+		*m = mm
+		return nil
+	default:
+		return fmt.Errorf("unknown schedule mode: %q", text)
+	}
+}
+
+// clientScheduleConfig controls when requests are sent in client mode.
+type clientScheduleConfig struct {
+	// Mode is the mode of sending requests. If empty, [scheduleOnce] is used.
+	Mode scheduleMode `json:"mode,omitempty"`
+
+	// Interval is the interval between requests.
+	// If zero, requests are sent every 2 seconds.
+	Interval jsonhelper.Duration `json:"interval,omitempty"`
+
+	// Attempts is the number of attempts in [scheduleOnce] mode.
+	// If zero, up to 5 attempts are sent.
+	Attempts int `json:"attempts,omitempty"`
+
+	// WatchFailures is the number of consecutive intervals without a response
+	// after which the path is considered down in [scheduleWatch] mode.
+	// If zero, the path is considered down after 3 intervals.
+	WatchFailures int `json:"watchFailures,omitempty"`
+}
+
+// mode returns the configured mode, or [scheduleOnce] if not configured.
+func (c clientScheduleConfig) mode() scheduleMode {
+	if c.Mode == "" {
+		return scheduleOnce
+	}
+	return c.Mode
+}
+
+// attempts returns the number of attempts in [scheduleOnce] mode.
+func (c clientScheduleConfig) attempts() int {
+	if c.Attempts == 0 {
+		return defaultClientAttempts
+	}
+	return c.Attempts
+}
+
+// clientHookConfig is the configuration of a hook command.
+type clientHookConfig struct {
+	// Command is the command line, run by the system shell.
+	Command string `json:"command"`
+
+	// Timeout is the maximum time the command may run.
+	// If zero, the command is killed after 30 seconds.
+	Timeout jsonhelper.Duration `json:"timeout,omitempty"`
+}
+
+// clientWebhookConfig is the configuration of a webhook.
+type clientWebhookConfig struct {
+	// URL is the URL the payload is posted to.
+	URL string `json:"url"`
+
+	// Secret is the key used to sign deliveries.
+	// If empty, deliveries are not signed.
+	Secret string `json:"secret,omitempty"`
+
+	// SecretFile is the path to a file containing the secret, used instead of Secret.
+	// A bare file name is looked up in $CREDENTIALS_DIRECTORY if set.
+	// The file must not be writable by its group, or accessible by others.
+	SecretFile string `json:"secretFile,omitempty"`
+
+	// Events is the list of event types delivered to the webhook.
+	// If empty, only changes of the client address are delivered.
+	Events []client.EventType `json:"events,omitempty"`
+
+	// MaxAttempts is the maximum number of delivery attempts.
+	// If zero, a delivery is attempted up to 5 times.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+
+	// Timeout is the timeout of each delivery attempt.
+	// If zero, each attempt times out after 10 seconds.
+	Timeout jsonhelper.Duration `json:"timeout,omitempty"`
+}
+
+var errWebhookSecretAndFile = errors.New("secret and secretFile are mutually exclusive")
+
+// loadClientConfig loads the client configuration from the JSON file at path.
+func loadClientConfig(path string) (clientConfig, error) {
+	var cc clientConfig
+	if err := decodeConfigFile(path, &cc); err != nil {
+		return clientConfig{}, err
+	}
+	return cc, nil
+}
+
+// loadSecrets reads the configured PSK and webhook secret files, derives the configured passphrase keys, and falls back to $OPDT_PSK if no PSK is configured.
+// Errors are qualified by the path of the setting.
+func (c *clientConfig) loadSecrets() error {
+	psk, err := secret.LoadKey(c.PSK, c.PSKFile, c.Argon2id)
+	if err != nil {
+		return validate.Problem{Path: keySourcePath("", c.PSKFile, c.Argon2id), Err: err}
+	}
+	if psk == nil {
+		if psk, err = secret.KeyFromEnv(pskEnv); err != nil {
+			return err
+		}
+	}
+	c.PSK, c.PSKFile, c.Argon2id = psk, "", nil
+
+	for _, s := range [...]struct {
+		path    string
+		servers []clientServerConfig
+	}{
+		{"fallbackServers", c.FallbackServers},
+		{"consensusServers", c.ConsensusServers},
+	} {
+		for i := range s.servers {
+			sc := &s.servers[i]
+			if sc.PSK, err = secret.LoadKey(sc.PSK, sc.PSKFile, sc.Argon2id); err != nil {
+				return validate.Problem{Path: keySourcePath(validate.Index(s.path, i), sc.PSKFile, sc.Argon2id), Err: err}
+			}
+			sc.PSKFile, sc.Argon2id = "", nil
+		}
+	}
+
+	for i := range c.Webhooks {
+		w := &c.Webhooks[i]
+		if w.SecretFile == "" {
+			continue
+		}
+		path := validate.Field(validate.Index("webhooks", i), "secretFile")
+		if w.Secret != "" {
+			return validate.Problem{Path: path, Err: errWebhookSecretAndFile}
+		}
+		b, err := secret.ReadFile(w.SecretFile)
+		if err != nil {
+			return validate.Problem{Path: path, Err: err}
+		}
+		w.Secret, w.SecretFile = string(b), ""
+	}
+
+	return nil
+}
+
+// clientConfig returns the configuration of a [client.Client].
+func (c *clientConfig) clientConfig() client.Config {
+	fallbackServers := make([]client.ServerConfig, len(c.FallbackServers))
+	for i, s := range c.FallbackServers {
+		fallbackServers[i] = s.serverConfig(c.PSK)
+	}
+
+	return client.Config{
+		ServerAddress:    c.Server,
+		ResolveInterval:  time.Duration(c.ResolveInterval),
+		BindAddress:      c.BindAddress,
+		PSK:              c.PSK,
+		FallbackServers:  fallbackServers,
+		Cooldown:         time.Duration(c.Cooldown),
+		SRVDomain:        c.SRVDomain,
+		SendLocalAddress: c.SendLocalAddress,
+	}
+}
+
+// hooks returns the configured hooks.
+func (c *clientConfig) hooks() []client.Hook {
+	hooks := make([]client.Hook, len(c.Hooks))
+	for i, h := range c.Hooks {
+		hooks[i] = client.Hook{
+			Command: h.Command,
+			Timeout: time.Duration(h.Timeout),
+		}
+	}
+	return hooks
+}
+
+// webhooks returns the configured webhooks.
+func (c *clientConfig) webhooks() []client.Webhook {
+	webhooks := make([]client.Webhook, len(c.Webhooks))
+	for i, w := range c.Webhooks {
+		webhooks[i] = client.Webhook{
+			URL:         w.URL,
+			Secret:      []byte(w.Secret),
+			Events:      w.Events,
+			MaxAttempts: w.MaxAttempts,
+			Timeout:     time.Duration(w.Timeout),
+		}
+	}
+	return webhooks
+}
+
+// stateServer returns the server recorded in the state file.
+func (c *clientConfig) stateServer() string {
+	if c.Server == "" {
+		return "_opdt._udp." + c.SRVDomain
+	}
+	return c.Server
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/database64128/opdt-go/dnsupdate"
+	"github.com/database64128/opdt-go/jsonhelper"
+	"github.com/database64128/opdt-go/secret"
+)
+
+// clientFlags are the flags of the client command.
+type clientFlags struct {
+	confPath          string
+	server            string
+	srvDomain         string
+	dualStack         string
+	psk               secret.Key
+	pskFile           string
+	bind              string
+	interval          time.Duration
+	attempts          int
+	sendLocalAddress  bool
+	fallbacks         stringSliceFlag
+	cooldown          time.Duration
+	resolveInterval   time.Duration
+	consensusServers  stringSliceFlag
+	quorum            int
+	output            outputFormat
+	stateFile         string
+	watch             bool
+	watchFailures     int
+	watchHook         string
+	webhookURLs       stringSliceFlag
+	webhookSecret     string
+	webhookSecretFile string
+	name              string
+	dnsUpdatePath     string
+	fingerprint       bool
+}
+
+func (f *clientFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.confPath, "conf", "", "Path to the JSON client config file, used instead of the other client flags")
+	fs.StringVar(&f.server, "server", "", "Server address, in the form host:port.\nA host name is resolved at start, and re-resolved every -resolveInterval, or after 3 requests without a response.")
+	fs.StringVar(&f.srvDomain, "srv", "", "Discover servers from the SRV records of _opdt._udp.<domain>, tried in the order of priority and weight.\nWhen -server is also specified, the discovered servers are tried after it and its fallbacks. The records are looked up again every -resolveInterval.")
+	fs.StringVar(&f.dualStack, "dualStack", "", "Discover the client address of both IPv4 and IPv6 in parallel, using the A and AAAA addresses of the specified server host:port.\nThe address of -bind is used for its own family, and its port for both families.")
+	fs.Var(&f.psk, "psk", "Pre-shared key.\nThe key is visible to other local users in the process list. Prefer -pskFile or the OPDT_PSK environment variable.")
+	fs.StringVar(&f.pskFile, "pskFile", "", "Path to a file containing the base64-encoded pre-shared key.\nA bare file name is looked up in $CREDENTIALS_DIRECTORY if set. The file must not be writable by its group, or accessible by others.")
+	fs.StringVar(&f.bind, "bind", "", "Bind address (default: let system choose)")
+	fs.DurationVar(&f.interval, "interval", 0, "Keep sending at specified interval")
+	fs.IntVar(&f.attempts, "attempts", defaultClientAttempts, "Number of attempts to send. Set to 0 to send indefinitely.")
+	fs.BoolVar(&f.sendLocalAddress, "sendLocalAddress", false, "Include the encrypted local address in requests, for the server's client inventory")
+	fs.Var(&f.fallbacks, "fallback", "Fallback server, in the form address[,pskFile], tried in order when the previous server times out or only returns errors. Can be specified multiple times.\nThe PSK is read from pskFile, like -pskFile, and defaults to the PSK of -psk, -pskFile or OPDT_PSK.")
+	fs.DurationVar(&f.cooldown, "cooldown", 5*time.Minute, "Period for which a failed server is skipped in favor of the next one")
+	fs.DurationVar(&f.resolveInterval, "resolveInterval", 10*time.Minute, "Interval between resolutions of server host names and SRV records")
+	fs.Var(&f.consensusServers, "consensusServer", "Additional server to query in one-shot mode, in the form address[,pskFile]. Can be specified multiple times.\nThe PSK is read from pskFile, like -pskFile, and defaults to the PSK of -psk, -pskFile or OPDT_PSK. The client address is only reported when -quorum servers agree.")
+	fs.IntVar(&f.quorum, "quorum", 0, "Number of servers that must report the same client address when -consensusServer is specified (default: majority)")
+	fs.TextVar(&f.output, "output", outputLog, "Output format of results.\nAvailable formats: log, plain, json, jsonl, env")
+	fs.StringVar(&f.stateFile, "stateFile", "", "Path to the client state file, atomically updated with the last known client address, when it was last confirmed, and the last error.\nIn watch mode, the last known address is read on startup, so that discovering it again does not emit a change event.")
+	fs.BoolVar(&f.watch, "watch", false, "Watch mode: keep sending, and only report when the client address changes, or when the path goes down or recovers")
+	fs.IntVar(&f.watchFailures, "watchFailures", 3, "Number of consecutive intervals without a response after which the path is considered down in watch mode")
+	fs.StringVar(&f.watchHook, "watchHook", "", "Shell command to run on each event in watch mode.\nThe event is passed in environment variables OPDT_EVENT, OPDT_OLD_ADDR, OPDT_OLD_PORT, OPDT_NEW_ADDR, OPDT_NEW_PORT, OPDT_FAILURES and OPDT_ERROR.")
+	fs.Var(&f.webhookURLs, "webhook", "URL to post a JSON payload to when the client address changes in watch mode. Can be specified multiple times.")
+	fs.StringVar(&f.webhookSecret, "webhookSecret", "", "Secret for signing webhook deliveries with HMAC-SHA256.\nThe secret is visible to other local users in the process list. Prefer -webhookSecretFile.")
+	fs.StringVar(&f.webhookSecretFile, "webhookSecretFile", "", "Path to a file containing the secret for signing webhook deliveries.\nA bare file name is looked up in $CREDENTIALS_DIRECTORY if set. The file must not be writable by its group, or accessible by others.")
+	fs.StringVar(&f.name, "name", "", "Name of this client in webhook payloads (default: hostname)")
+	fs.StringVar(&f.dnsUpdatePath, "dnsUpdate", "", "Path to the JSON configuration file for updating DNS records with RFC 2136 dynamic updates on the first result and when the client address changes")
+	fs.BoolVar(&f.fingerprint, "fingerprint", false, "Print the fingerprint of each configured key and exit, so that the keys of clients and servers can be checked to match without revealing them")
+}
+
+// clientConfig returns the client configuration built from the flags.
+func (f *clientFlags) clientConfig() (clientConfig, error) {
+	cc := clientConfig{
+		Server:           f.server,
+		SRVDomain:        f.srvDomain,
+		PSK:              f.psk,
+		PSKFile:          f.pskFile,
+		Quorum:           f.quorum,
+		BindAddress:      f.bind,
+		SendLocalAddress: f.sendLocalAddress,
+		ResolveInterval:  jsonhelper.Duration(f.resolveInterval),
+		Cooldown:         jsonhelper.Duration(f.cooldown),
+		Schedule: clientScheduleConfig{
+			Mode:          scheduleOnce,
+			Interval:      jsonhelper.Duration(f.interval),
+			Attempts:      f.attempts,
+			WatchFailures: f.watchFailures,
+		},
+		Output:    f.output,
+		StateFile: f.stateFile,
+		Name:      f.name,
+	}
+
+	if f.dualStack != "" {
+		cc.Server = f.dualStack
+		cc.DualStack = true
+	}
+
+	switch {
+	case f.watch:
+		cc.Schedule.Mode = scheduleWatch
+	case f.attempts == 0:
+		cc.Schedule.Mode = scheduleContinuous
+	}
+
+	for _, s := range f.fallbacks {
+		cc.FallbackServers = append(cc.FallbackServers, parseClientServerConfig(s))
+	}
+
+	for _, s := range f.consensusServers {
+		cc.ConsensusServers = append(cc.ConsensusServers, parseClientServerConfig(s))
+	}
+
+	if f.watchHook != "" {
+		cc.Hooks = []clientHookConfig{{Command: f.watchHook}}
+	}
+
+	for _, url := range f.webhookURLs {
+		cc.Webhooks = append(cc.Webhooks, clientWebhookConfig{
+			URL:        url,
+			Secret:     f.webhookSecret,
+			SecretFile: f.webhookSecretFile,
+		})
+	}
+
+	if f.dnsUpdatePath != "" {
+		var dc dnsupdate.Config
+		if err := jsonhelper.OpenAndDecodeDisallowUnknownFields(f.dnsUpdatePath, &dc); err != nil {
+			return clientConfig{}, fmt.Errorf("failed to load DNS update config %s: %w", f.dnsUpdatePath, err)
+		}
+		cc.DNSUpdate = &dc
+	}
+
+	return cc, nil
+}
+
+// parseClientServerConfig parses a server in the form "address[,pskFile]".
+// The PSK file is read when the secrets are loaded, so that keys never appear in the process list.
+func parseClientServerConfig(s string) clientServerConfig {
+	address, pskFile, _ := strings.Cut(s, ",")
+	return clientServerConfig{Address: address, PSKFile: pskFile}
+}
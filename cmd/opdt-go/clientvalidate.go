@@ -0,0 +1,200 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/database64128/opdt-go/secret"
+	"github.com/database64128/opdt-go/validate"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// validate checks the configuration without opening any sockets, and returns all problems found.
+// Keys are loaded from their files and derived from their passphrases, but not stored in the config.
+func (c *clientConfig) validate() validate.Problems {
+	var ps validate.Problems
+
+	if c.Server == "" && c.SRVDomain == "" {
+		ps.Addf("server", "neither server nor srvDomain configured")
+	}
+	if c.Server != "" {
+		if err := checkHostPort(c.Server); err != nil {
+			ps.Add("server", err)
+		}
+	}
+
+	validateKey(&ps, "", c.PSK, c.PSKFile, c.Argon2id, true)
+
+	for _, s := range [...]struct {
+		path    string
+		servers []clientServerConfig
+	}{
+		{"fallbackServers", c.FallbackServers},
+		{"consensusServers", c.ConsensusServers},
+	} {
+		for i, sc := range s.servers {
+			path := validate.Index(s.path, i)
+			if err := checkHostPort(sc.Address); err != nil {
+				ps.Add(validate.Field(path, "address"), err)
+			}
+			validateKey(&ps, path, sc.PSK, sc.PSKFile, sc.Argon2id, false)
+		}
+	}
+
+	mode := c.Schedule.mode()
+
+	// A dual-stack query is a one-shot query of a single server, with a result for each family.
+	if c.DualStack {
+		if mode != scheduleOnce {
+			ps.Addf("schedule.mode", "dualStack only supports once mode")
+		}
+		for _, s := range [...]struct {
+			path string
+			set  bool
+		}{
+			{"srvDomain", c.SRVDomain != ""},
+			{"fallbackServers", len(c.FallbackServers) > 0},
+			{"consensusServers", len(c.ConsensusServers) > 0},
+			{"stateFile", c.StateFile != ""},
+			{"dnsUpdate", c.DNSUpdate != nil},
+		} {
+			if s.set {
+				ps.Addf(s.path, "not supported with dualStack")
+			}
+		}
+	}
+
+	if len(c.ConsensusServers) > 0 {
+		if mode != scheduleOnce {
+			ps.Addf("consensusServers", "only supported in once mode")
+		}
+		if c.Server == "" || c.SRVDomain != "" {
+			ps.Addf("consensusServers", "requires server, and does not support srvDomain")
+		}
+		if n := 1 + len(c.ConsensusServers); c.Quorum < 0 || c.Quorum > n {
+			ps.Addf("quorum", "quorum %d out of range for %d servers", c.Quorum, n)
+		}
+	}
+
+	if c.BindAddress != "" {
+		if _, _, err := net.SplitHostPort(c.BindAddress); err != nil {
+			ps.Add("bind", err)
+		}
+	}
+	if c.ResolveInterval < 0 {
+		ps.Addf("resolveInterval", "negative interval %v", time.Duration(c.ResolveInterval))
+	}
+	if c.Cooldown < 0 {
+		ps.Addf("cooldown", "negative cool-down %v", time.Duration(c.Cooldown))
+	}
+	if c.Schedule.Interval < 0 {
+		ps.Addf("schedule.interval", "negative interval %v", time.Duration(c.Schedule.Interval))
+	}
+	if c.Schedule.Attempts < 0 {
+		ps.Addf("schedule.attempts", "negative number of attempts %d", c.Schedule.Attempts)
+	}
+	if c.Schedule.WatchFailures < 0 {
+		ps.Addf("schedule.watchFailures", "negative number of failures %d", c.Schedule.WatchFailures)
+	}
+
+	for i, h := range c.Hooks {
+		if h.Command == "" {
+			ps.Addf(validate.Field(validate.Index("hooks", i), "command"), "empty command")
+		}
+	}
+
+	for i, w := range c.Webhooks {
+		path := validate.Field(validate.Index("webhooks", i), "url")
+		u, err := url.Parse(w.URL)
+		switch {
+		case err != nil:
+			ps.Add(path, err)
+		case u.Scheme == "http":
+			ps.Warnf(path, "payloads are sent in clear text")
+		case u.Scheme != "https":
+			ps.Addf(path, "unsupported scheme %q", u.Scheme)
+		}
+
+		if w.SecretFile != "" {
+			path := validate.Field(validate.Index("webhooks", i), "secretFile")
+			if w.Secret != "" {
+				ps.Add(path, errWebhookSecretAndFile)
+			} else if _, err := secret.ReadFile(w.SecretFile); err != nil {
+				ps.Add(path, err)
+			}
+		}
+	}
+
+	if c.DNSUpdate != nil {
+		if _, err := c.DNSUpdate.Updater(); err != nil {
+			ps.Add("dnsUpdate", err)
+		}
+		if c.DNSUpdate.TSIG == nil {
+			ps.Warnf("dnsUpdate.tsig", "updates are not authenticated")
+		}
+	}
+
+	if mode != scheduleWatch && (len(c.Hooks) > 0 || len(c.Webhooks) > 0) {
+		ps.Warnf("schedule.mode", "hooks and webhooks are only used in watch mode")
+	}
+
+	return ps
+}
+
+// validateKey checks the key configured at path.
+// If required, a missing key is an error, unless $OPDT_PSK is set.
+func validateKey(ps *validate.Problems, path string, psk secret.Key, pskFile string, argon2id *secret.Argon2idConfig, required bool) {
+	sourcePath := keySourcePath(path, pskFile, argon2id)
+	if argon2id != nil {
+		for _, w := range argon2id.Warnings() {
+			ps.Warnf(sourcePath, "%s", w)
+		}
+	}
+
+	key, err := secret.LoadKey(psk, pskFile, argon2id)
+	if err != nil {
+		ps.Add(sourcePath, err)
+		return
+	}
+	if key == nil {
+		if !required {
+			return
+		}
+		if key, err = secret.KeyFromEnv(pskEnv); err != nil {
+			ps.Add(sourcePath, err)
+			return
+		}
+		if key == nil {
+			ps.Addf(sourcePath, "no key: configure psk, pskFile or argon2id, or set $%s", pskEnv)
+			return
+		}
+	}
+
+	if _, err = chacha20poly1305.NewX(key); err != nil {
+		ps.Addf(sourcePath, "key is %d bytes, expected %d: %w", len(key), chacha20poly1305.KeySize, err)
+	}
+}
+
+// keySourcePath returns the path of the setting the key at path is configured with.
+func keySourcePath(path, pskFile string, argon2id *secret.Argon2idConfig) string {
+	switch {
+	case pskFile != "":
+		return validate.Field(path, "pskFile")
+	case argon2id != nil:
+		return validate.Field(path, "argon2id")
+	default:
+		return validate.Field(path, "psk")
+	}
+}
+
+// checkHostPort returns an error if address is not in the form "host:port".
+func checkHostPort(address string) error {
+	_, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	_, err = strconv.ParseUint(portString, 10, 16)
+	return err
+}
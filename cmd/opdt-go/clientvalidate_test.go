@@ -0,0 +1,28 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestClientConfigValidateDualStack(t *testing.T) {
+	c := clientConfig{
+		Server:    "opdt.example.com:20220",
+		DualStack: true,
+		PSK:       make([]byte, 32),
+		Schedule:  clientScheduleConfig{Mode: scheduleWatch},
+		StateFile: "/var/lib/opdt-go/state.json",
+	}
+
+	var errorPaths []string
+	for _, p := range c.validate() {
+		if !p.Warning {
+			errorPaths = append(errorPaths, p.Path)
+		}
+	}
+
+	expectedErrorPaths := []string{"schedule.mode", "stateFile"}
+	if !slices.Equal(errorPaths, expectedErrorPaths) {
+		t.Errorf("Got errors at %q, expected %q", errorPaths, expectedErrorPaths)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/database64128/opdt-go/client"
+	"go.uber.org/zap"
+)
+
+// resolveServerConfig resolves the host name of a server configured by address.
+func resolveServerConfig(ctx context.Context, sc client.ServerConfig) (client.ServerConfig, error) {
+	if sc.AddrPort.IsValid() {
+		return sc, nil
+	}
+
+	host, portString, err := net.SplitHostPort(sc.Address)
+	if err != nil {
+		return client.ServerConfig{}, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return client.ServerConfig{}, fmt.Errorf("bad port %q: %w", portString, err)
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return client.ServerConfig{}, err
+	}
+	if len(addrs) == 0 {
+		return client.ServerConfig{}, fmt.Errorf("%w: %s", client.ErrNoServerAddress, host)
+	}
+
+	sc.AddrPort = netip.AddrPortFrom(addrs[0].Unmap(), uint16(port))
+	return sc, nil
+}
+
+// queryConsensus queries the servers once, logs each server's response, and returns the agreed result.
+func queryConsensus(ctx context.Context, c *client.ConsensusClient, interval time.Duration, attempts int, logger *zap.Logger) client.Result {
+	cr, err := c.Query(ctx, interval, attempts)
+	if err != nil {
+		return resultFromGet(client.Result{}, err)
+	}
+
+	for _, resp := range cr.Responses {
+		if resp.Err != nil {
+			logger.Debug("Server did not report client address",
+				zap.Stringer("serverAddress", resp.ServerAddrPort),
+				zap.Error(resp.Err),
+			)
+			continue
+		}
+		logger.Debug("Server reported client address",
+			zap.Stringer("serverAddress", resp.ServerAddrPort),
+			zap.Stringer("clientAddress", resp.ClientAddrPort),
+		)
+	}
+
+	if cr.DestinationDependent() {
+		logger.Warn("Servers reported different ports for the same IP address, the NAT mapping is destination-dependent")
+	}
+
+	clientAddrPort, err := cr.ClientAddrPort()
+	return resultFromGet(client.OkResult(clientAddrPort), err)
+}
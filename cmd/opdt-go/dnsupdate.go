@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/database64128/opdt-go/client"
+	"github.com/database64128/opdt-go/dnsupdate"
+	"go.uber.org/zap"
+)
+
+const (
+	// dnsUpdateMinBackoff is the delay before retrying a failed DNS update for the first time.
+	dnsUpdateMinBackoff = time.Second
+
+	// dnsUpdateMaxBackoff is the maximum delay between retries of a failed DNS update.
+	dnsUpdateMaxBackoff = time.Minute
+)
+
+// dnsUpdateQueue keeps DNS records up to date with results.
+//
+// The records are updated on the first successful result, whatever the state file says,
+// as they may have been changed since, and then whenever the client address changes.
+type dnsUpdateQueue struct {
+	updater  *dnsupdate.Updater
+	ch       chan netip.AddrPort
+	addrPort netip.AddrPort
+	logger   *zap.Logger
+	wg       sync.WaitGroup
+}
+
+// newDNSUpdateQueue returns a new DNS update queue, and starts its update goroutine.
+// Updates stop when ctx is canceled.
+func newDNSUpdateQueue(ctx context.Context, updater *dnsupdate.Updater, logger *zap.Logger) *dnsUpdateQueue {
+	q := &dnsUpdateQueue{
+		updater: updater,
+		ch:      make(chan netip.AddrPort, 1),
+		logger:  logger,
+	}
+	q.wg.Go(func() {
+		q.run(ctx)
+	})
+	return q
+}
+
+// Write queues an update if the result is successful and its client address differs from the last one queued.
+//
+// Write must not be called concurrently.
+func (q *dnsUpdateQueue) Write(result client.Result) {
+	if !result.IsOk() || result.ClientAddrPort == q.addrPort {
+		return
+	}
+	q.addrPort = result.ClientAddrPort
+
+	// Replace any update not yet started.
+	for {
+		select {
+		case q.ch <- result.ClientAddrPort:
+			return
+		default:
+		}
+		select {
+		case <-q.ch:
+		default:
+		}
+	}
+}
+
+// run updates DNS records to the latest client address received from q.ch.
+// Failed updates are retried with exponential backoff, until they succeed, a newer address arrives,
+// or the queue is closed.
+func (q *dnsUpdateQueue) run(ctx context.Context) {
+	for clientAddrPort := range q.ch {
+		backoff := dnsUpdateMinBackoff
+
+		for {
+			err := q.updater.Update(ctx, clientAddrPort)
+			if err == nil {
+				q.logger.Info("Updated DNS records", zap.Stringer("clientAddress", clientAddrPort))
+				break
+			}
+
+			q.logger.Warn("Failed to update DNS records",
+				zap.Stringer("clientAddress", clientAddrPort),
+				zap.Duration("retryIn", backoff),
+				zap.Error(err),
+			)
+
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				backoff = min(backoff*2, dnsUpdateMaxBackoff)
+			case next, ok := <-q.ch:
+				timer.Stop()
+				if !ok {
+					return
+				}
+				clientAddrPort = next
+				backoff = dnsUpdateMinBackoff
+			}
+		}
+	}
+}
+
+// Close waits for the queued update to finish. A failed update is not retried after Close is called.
+func (q *dnsUpdateQueue) Close() {
+	close(q.ch)
+	q.wg.Wait()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/database64128/opdt-go/client"
+)
+
+func TestDNSUpdateQueueWrite(t *testing.T) {
+	// Without the update goroutine, queued updates stay in the channel.
+	q := &dnsUpdateQueue{ch: make(chan netip.AddrPort, 1)}
+
+	first := netip.MustParseAddrPort("[2001:db8::1]:20220")
+	second := netip.MustParseAddrPort("[2001:db8::2]:20220")
+
+	for _, c := range []struct {
+		result   client.Result
+		expected netip.AddrPort
+	}{
+		// The first successful result is always pushed.
+		{client.Result{Err: client.Error{Message: "timeout"}}, netip.AddrPort{}},
+		{client.Result{ClientAddrPort: first}, first},
+		{client.Result{ClientAddrPort: first}, netip.AddrPort{}},
+		{client.Result{Err: client.Error{Message: "timeout"}}, netip.AddrPort{}},
+		{client.Result{ClientAddrPort: second}, second},
+	} {
+		q.Write(c.result)
+
+		var got netip.AddrPort
+		select {
+		case got = <-q.ch:
+		default:
+		}
+		if got != c.expected {
+			t.Errorf("Got update to %s after result %+v, expected %s", got, c.result, c.expected)
+		}
+	}
+}
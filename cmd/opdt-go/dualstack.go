@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// resolveDualStack resolves the server in the form "host:port" to an IPv4 and an IPv6 address.
+// A family without an address is returned as the zero value.
+func resolveDualStack(ctx context.Context, server string) (ipv4, ipv6 netip.AddrPort, err error) {
+	host, portString, err := net.SplitHostPort(server)
+	if err != nil {
+		return netip.AddrPort{}, netip.AddrPort{}, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, netip.AddrPort{}, fmt.Errorf("bad port %q: %w", portString, err)
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrPort := netip.AddrPortFrom(addr.Unmap(), uint16(port))
+		if addrPort.Addr().Is4() {
+			return addrPort, netip.AddrPort{}, nil
+		}
+		return netip.AddrPort{}, addrPort, nil
+	}
+
+	addrs4, err4 := net.DefaultResolver.LookupNetIP(ctx, "ip4", host)
+	if len(addrs4) > 0 {
+		ipv4 = netip.AddrPortFrom(addrs4[0].Unmap(), uint16(port))
+	}
+	addrs6, err6 := net.DefaultResolver.LookupNetIP(ctx, "ip6", host)
+	if len(addrs6) > 0 {
+		ipv6 = netip.AddrPortFrom(addrs6[0], uint16(port))
+	}
+
+	if !ipv4.IsValid() && !ipv6.IsValid() {
+		return netip.AddrPort{}, netip.AddrPort{}, errors.Join(err4, err6)
+	}
+	return ipv4, ipv6, nil
+}
+
+// dualStackBindAddresses returns the IPv4 and IPv6 bind addresses for the bind address.
+// The address is used for its own family, and the port for both families.
+func dualStackBindAddresses(bind string) (ipv4, ipv6 string, err error) {
+	if bind == "" {
+		return "", "", nil
+	}
+
+	host, port, err := net.SplitHostPort(bind)
+	if err != nil {
+		return "", "", err
+	}
+
+	ipv4 = net.JoinHostPort("0.0.0.0", port)
+	ipv6 = net.JoinHostPort("::", port)
+
+	if host != "" {
+		addr, err := netip.ParseAddr(host)
+		if err != nil {
+			return "", "", err
+		}
+		if addr.Unmap().Is4() {
+			ipv4 = bind
+		} else {
+			ipv6 = bind
+		}
+	}
+
+	return ipv4, ipv6, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/database64128/opdt-go/server"
+)
+
+// writeServerFingerprints writes the fingerprint of each key of the server config,
+// one per line, in the form "server<TAB>key<TAB>fingerprint".
+func writeServerFingerprints(w io.Writer, sc *server.Config) error {
+	fingerprints, err := sc.KeyFingerprints()
+	if err != nil {
+		return err
+	}
+	for _, f := range fingerprints {
+		if _, err = fmt.Fprintf(w, "%s\t%s\t%s\n", f.Server, f.Key, f.Fingerprint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeClientFingerprints writes the fingerprint of each key of the client config,
+// one per line, in the form "server<TAB>fingerprint".
+// The secrets of the config must have been loaded.
+func writeClientFingerprints(w io.Writer, cc *clientConfig) error {
+	if _, err := fmt.Fprintf(w, "%s\t%s\n", cc.stateServer(), cc.PSK.Fingerprint()); err != nil {
+		return err
+	}
+
+	for _, servers := range [...][]clientServerConfig{cc.FallbackServers, cc.ConsensusServers} {
+		for _, s := range servers {
+			psk := s.PSK
+			if len(psk) == 0 {
+				psk = cc.PSK
+			}
+			if _, err := fmt.Fprintf(w, "%s\t%s\n", s.Address, psk.Fingerprint()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// argon2idSaltSize is the size of salts generated by genpsk -salt.
+const argon2idSaltSize = 16
+
+func runGenPSKCommand(name string, args []string) {
+	var (
+		count int
+		salt  bool
+	)
+
+	fs := newFlagSet(name, "[flags]", "Generate random pre-shared keys, and print them in base64, one per line.")
+	fs.IntVar(&count, "n", 1, "Number of keys to generate")
+	fs.BoolVar(&salt, "salt", false, "Generate salts for Argon2id passphrase keys instead of keys")
+	_ = fs.Parse(args)
+
+	size := chacha20poly1305.KeySize
+	if salt {
+		size = argon2idSaltSize
+	}
+
+	b := make([]byte, size)
+	for range count {
+		rand.Read(b)
+		if _, err := fmt.Println(base64.StdEncoding.EncodeToString(b)); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to write key:", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// legacyFlags maps the flags of the command line without subcommands, which are deprecated,
+// to the flags of the server and client commands. Flags not listed kept their names.
+var legacyFlags = map[string]string{
+	"server":                 "conf",
+	"clientConf":             "conf",
+	"client":                 "server",
+	"clientSRV":              "srv",
+	"clientDualStack":        "dualStack",
+	"clientPSK":              "psk",
+	"clientPSKFile":          "pskFile",
+	"clientBind":             "bind",
+	"clientInterval":         "interval",
+	"clientAttempts":         "attempts",
+	"clientSendLocalAddress": "sendLocalAddress",
+	"clientResolveInterval":  "resolveInterval",
+	"clientFallback":         "fallback",
+	"clientCooldown":         "cooldown",
+	"clientName":             "name",
+}
+
+// legacyServerListFlags are the legacy flags whose values took an inline PSK after a comma,
+// where the new flags take the path of a key file.
+var legacyServerListFlags = [...]string{"clientFallback", "consensusServer"}
+
+// translateLegacyArgs returns the command and its arguments for a deprecated command line without subcommands.
+// The command is server if -server is specified, and client otherwise.
+// The returned renames list each renamed flag, for the deprecation warning.
+func translateLegacyArgs(args []string) (name string, translated []string, renames []string, err error) {
+	name = "client"
+	translated = make([]string, len(args))
+	var serverListFlag string
+
+	for i, arg := range args {
+		translated[i] = arg
+
+		if serverListFlag != "" {
+			if strings.Contains(arg, ",") {
+				return "", nil, nil, fmt.Errorf("-%s no longer takes an inline PSK, put the key in a file and pass its path instead", serverListFlag)
+			}
+			serverListFlag = ""
+			continue
+		}
+
+		if arg == "--" || arg == "-" || !strings.HasPrefix(arg, "-") {
+			continue
+		}
+
+		dashes := "-"
+		if strings.HasPrefix(arg, "--") {
+			dashes = "--"
+		}
+		flagName, value, hasValue := strings.Cut(arg[len(dashes):], "=")
+
+		if flagName == "server" {
+			name = "server"
+		}
+
+		for _, f := range legacyServerListFlags {
+			if flagName != f {
+				continue
+			}
+			if !hasValue {
+				serverListFlag = f
+			} else if strings.Contains(value, ",") {
+				return "", nil, nil, fmt.Errorf("-%s no longer takes an inline PSK, put the key in a file and pass its path instead", f)
+			}
+		}
+
+		newName, ok := legacyFlags[flagName]
+		if !ok {
+			continue
+		}
+		if rename := "-" + flagName + " is now -" + newName; !slices.Contains(renames, rename) {
+			renames = append(renames, rename)
+		}
+		if hasValue {
+			translated[i] = dashes + newName + "=" + value
+		} else {
+			translated[i] = dashes + newName
+		}
+	}
+
+	return name, translated, renames, nil
+}
+
+// runLegacy runs a deprecated command line without subcommands, after translating it to a subcommand.
+func runLegacy(args []string) {
+	name, translated, renames, err := translateLegacyArgs(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to translate the deprecated command line:", err)
+		os.Exit(2)
+	}
+
+	fmt.Fprintf(os.Stderr, "Warning: running %s without a command is deprecated, and will stop working in a future release. Run '%s %s' instead", os.Args[0], os.Args[0], name)
+	if len(renames) > 0 {
+		fmt.Fprintf(os.Stderr, ", where %s", strings.Join(renames, ", "))
+	}
+	fmt.Fprintln(os.Stderr, ".")
+
+	for _, c := range commands {
+		if c.name == name {
+			c.run(name, translated)
+			return
+		}
+	}
+}
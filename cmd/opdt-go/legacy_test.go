@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTranslateLegacyArgs(t *testing.T) {
+	for _, c := range []struct {
+		args       []string
+		name       string
+		translated []string
+		err        bool
+	}{
+		{
+			args:       []string{"-server", "/etc/opdt-go/config.json", "-zapConf", "systemd"},
+			name:       "server",
+			translated: []string{"-conf", "/etc/opdt-go/config.json", "-zapConf", "systemd"},
+		},
+		{
+			args:       []string{"-client", "[2001:db8::1]:20220", "--clientPSK=XbQZKDJTbbhuSwF0muQx6L9swsAmf0VOYIApri7nHUQ=", "-clientInterval", "30s", "-watch"},
+			name:       "client",
+			translated: []string{"-server", "[2001:db8::1]:20220", "--psk=XbQZKDJTbbhuSwF0muQx6L9swsAmf0VOYIApri7nHUQ=", "-interval", "30s", "-watch"},
+		},
+		{
+			args:       []string{"-client", "opdt.example.com:20220", "-clientFallback", "[2001:db8::2]:20220"},
+			name:       "client",
+			translated: []string{"-server", "opdt.example.com:20220", "-fallback", "[2001:db8::2]:20220"},
+		},
+		{
+			args: []string{"-client", "opdt.example.com:20220", "-clientFallback", "[2001:db8::2]:20220,XbQZKDJTbbhuSwF0muQx6L9swsAmf0VOYIApri7nHUQ="},
+			err:  true,
+		},
+		{
+			args: []string{"-client", "opdt.example.com:20220", "-consensusServer=[2001:db8::2]:20220,XbQZKDJTbbhuSwF0muQx6L9swsAmf0VOYIApri7nHUQ="},
+			err:  true,
+		},
+	} {
+		name, translated, _, err := translateLegacyArgs(c.args)
+		if c.err {
+			if err == nil {
+				t.Errorf("Translating %q succeeded, expected an error", c.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Failed to translate %q: %v", c.args, err)
+			continue
+		}
+		if name != c.name || !slices.Equal(translated, c.translated) {
+			t.Errorf("Got %s %q from %q, expected %s %q", name, translated, c.args, c.name, c.translated)
+		}
+	}
+}
@@ -2,158 +2,133 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
 	"flag"
 	"fmt"
-	"net/netip"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
-	"time"
 
-	"github.com/database64128/opdt-go/client"
 	"github.com/database64128/opdt-go/jsonhelper"
 	"github.com/database64128/opdt-go/logging"
-	"github.com/database64128/opdt-go/server"
+	"github.com/database64128/opdt-go/validate"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-type byteSliceFlag []byte
+// command is a subcommand of opdt-go.
+type command struct {
+	name    string
+	summary string
 
-func (b byteSliceFlag) String() string {
-	return base64.StdEncoding.EncodeToString(b)
+	// run runs the command with the arguments after the command name.
+	run func(name string, args []string)
 }
 
-func (b *byteSliceFlag) Set(s string) error {
-	bb, err := base64.StdEncoding.DecodeString(s)
-	if err != nil {
-		return err
+// commands are the subcommands, in the order they are listed in the usage.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"server", "Run servers from a config file", runServerCommand},
+		{"client", "Discover the client address", runClientCommand},
+		{"genpsk", "Generate random pre-shared keys", runGenPSKCommand},
+		{"check-config", "Check server and client config files", runCheckConfigCommand},
 	}
-	*b = bb
-	return nil
 }
 
-var (
-	serverConfPath string
-	clientServer   netip.AddrPort
-	clientPSK      byteSliceFlag
-	clientBind     string
-	clientInterval time.Duration
-	clientAttempts int
-	zapConf        string
-	logLevel       zapcore.Level
-)
-
-func init() {
-	flag.StringVar(&serverConfPath, "server", "", "Run as server using the specified config file")
-	flag.TextVar(&clientServer, "client", netip.AddrPort{}, "Run as client using the specified server address")
-	flag.Var(&clientPSK, "clientPSK", "Pre-shared key in client mode")
-	flag.StringVar(&clientBind, "clientBind", "", "Bind address in client mode (default: let system choose)")
-	flag.DurationVar(&clientInterval, "clientInterval", 0, "Keep sending at specified interval in client mode")
-	flag.IntVar(&clientAttempts, "clientAttempts", 5, "Number of attempts to send in client mode. Set to 0 to send indefinitely.")
-	flag.StringVar(&zapConf, "zapConf", "console", "Preset name or path to the JSON configuration file for building the zap logger.\nAvailable presets: console, console-nocolor, console-notime, systemd, production, development")
-	flag.TextVar(&logLevel, "logLevel", zapcore.InfoLevel, "Log level for the console and systemd presets.\nAvailable levels: debug, info, warn, error, dpanic, panic, fatal")
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
 }
 
 func main() {
-	flag.Parse()
-
-	serverMode := serverConfPath != ""
-	clientMode := clientServer.IsValid()
-	if serverMode == clientMode {
-		fmt.Fprintln(os.Stderr, "Either -server <path> or -client <address> must be specified.")
-		flag.Usage()
-		os.Exit(1)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
 	}
 
-	logger, err := logging.NewZapLogger(zapConf, logLevel)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to build logger:", err)
-		os.Exit(1)
+	name := os.Args[1]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		usage()
+		return
 	}
-	defer logger.Sync()
-
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-ctx.Done()
-		stop()
-	}()
-
-	if serverMode {
-		var sc server.Config
-		if err = jsonhelper.OpenAndDecodeDisallowUnknownFields(serverConfPath, &sc); err != nil {
-			logger.Fatal("Failed to load server config",
-				zap.String("path", serverConfPath),
-				zap.Error(err),
-			)
-		}
 
-		s, err := sc.Server(logger)
-		if err != nil {
-			logger.Fatal("Failed to initialize server",
-				zap.String("listenAddress", sc.ListenAddress),
-				zap.Binary("psk", sc.PSK),
-				zap.Error(err),
-			)
+	for _, c := range commands {
+		if c.name == name {
+			c.run(name, os.Args[2:])
+			return
 		}
+	}
 
-		if err = s.Start(ctx); err != nil {
-			logger.Fatal("Failed to start server",
-				zap.String("listenAddress", sc.ListenAddress),
-				zap.Binary("psk", sc.PSK),
-				zap.Error(err),
-			)
-		}
+	// Before subcommands, the command line consisted of flags only.
+	if strings.HasPrefix(name, "-") {
+		runLegacy(os.Args[1:])
+		return
+	}
 
-		logger.Info("Started server", zap.String("listenAddress", sc.ListenAddress))
+	fmt.Fprintf(os.Stderr, "Unknown command %q.\n\n", name)
+	usage()
+	os.Exit(2)
+}
 
-		<-ctx.Done()
-		s.Stop()
-		logger.Info("Stopped server")
+// newFlagSet returns a new flag set for the command,
+// with a usage message that lists the command's arguments and flags.
+func newFlagSet(name, arguments, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n\n%s\n\nFlags:\n", os.Args[0], name, arguments, description)
+		fs.PrintDefaults()
 	}
+	return fs
+}
 
-	if clientMode {
-		clientConfig := client.Config{
-			ServerAddrPort: clientServer,
-			BindAddress:    clientBind,
-			PSK:            clientPSK,
-		}
+// loggingFlags are the logging flags shared by commands that log.
+type loggingFlags struct {
+	zapConf  string
+	logLevel zapcore.Level
+}
 
-		c, err := clientConfig.Client()
-		if err != nil {
-			logger.Fatal("Failed to initialize client",
-				zap.Stringer("serverAddress", clientServer),
-				zap.String("bindAddress", clientBind),
-				zap.Binary("psk", clientPSK),
-				zap.Error(err),
-			)
-		}
+func (f *loggingFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.zapConf, "zapConf", "console", "Preset name or path to the JSON configuration file for building the zap logger.\nAvailable presets: console, console-nocolor, console-notime, systemd, production, development")
+	fs.TextVar(&f.logLevel, "logLevel", zapcore.InfoLevel, "Log level for the console and systemd presets.\nAvailable levels: debug, info, warn, error, dpanic, panic, fatal")
+}
 
-		if clientAttempts == 0 {
-			resultCh, err := c.Run(ctx, clientInterval)
-			if err != nil {
-				logger.Fatal("Failed to start client",
-					zap.Stringer("serverAddress", clientServer),
-					zap.String("bindAddress", clientBind),
-					zap.Binary("psk", clientPSK),
-					zap.Error(err),
-				)
-			}
-
-			for result := range resultCh {
-				if result.IsOk() {
-					logger.Info("Got client address", zap.String("clientAddress", result.ClientAddrPort.String()))
-				} else {
-					logger.Warn("Failed to get client address", zap.Error(result.Err))
-				}
-			}
-		} else {
-			clientAddrPort, err := c.Get(ctx, clientInterval, clientAttempts)
-			if err != nil {
-				logger.Error("Failed to get client address", zap.Error(err))
-			}
-			logger.Info("Got client address", zap.String("clientAddress", clientAddrPort.String()))
-		}
+// newLogger builds the logger, and exits if it fails.
+func (f *loggingFlags) newLogger() (*zap.Logger, zap.AtomicLevel) {
+	atomicLevel := zap.NewAtomicLevelAt(f.logLevel)
+	logger, err := logging.NewZapLogger(f.zapConf, atomicLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to build logger:", err)
+		os.Exit(1)
 	}
+	return logger, atomicLevel
+}
+
+// decodeConfigFile decodes the JSON config file at path into v, with unknown fields disallowed.
+// Decoding errors are returned as a [validate.Problem] at the path of the offending setting.
+func decodeConfigFile(path string, v any) error {
+	err := jsonhelper.OpenAndDecodeDisallowUnknownFields(path, v)
+	if err == nil {
+		return nil
+	}
+	data, rerr := os.ReadFile(path)
+	if rerr != nil {
+		return err
+	}
+	return validate.DecodeError(data, v, err)
+}
+
+// signalContext returns a context that is canceled on SIGINT or SIGTERM.
+func signalContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx
 }
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/database64128/opdt-go/client"
+	"go.uber.org/zap"
+)
+
+// outputFormat is the format of client results.
+type outputFormat string
+
+const (
+	// outputLog logs results with the zap logger.
+	outputLog outputFormat = "log"
+
+	// outputPlain prints the client address as ip:port on stdout, and errors on stderr.
+	outputPlain outputFormat = "plain"
+
+	// outputJSON prints each result as an indented JSON object on stdout.
+	outputJSON outputFormat = "json"
+
+	// outputJSONL prints each result as a single line of JSON on stdout.
+	outputJSONL outputFormat = "jsonl"
+
+	// outputEnv prints each result as shell variable assignments on stdout.
+	outputEnv outputFormat = "env"
+)
+
+// MarshalText implements [encoding.TextMarshaler].
+func (f outputFormat) MarshalText() ([]byte, error) {
+	return []byte(f), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler].
+func (f *outputFormat) UnmarshalText(text []byte) error {
+	switch ff := outputFormat(text); ff {
+	case outputLog, outputPlain, outputJSON, outputJSONL, outputEnv:
+		*f = ff
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q", text)
+	}
+}
+
+// outputResult is the JSON representation of a client result.
+type outputResult struct {
+	Time          time.Time      `json:"time"`
+	OK            bool           `json:"ok"`
+	Server        netip.AddrPort `json:"server,omitzero"`
+	ClientAddress netip.AddrPort `json:"clientAddress,omitzero"`
+	Address       netip.Addr     `json:"address,omitzero"`
+	Port          uint16         `json:"port,omitempty"`
+	Error         *outputError   `json:"error,omitempty"`
+}
+
+// outputError is the JSON representation of a [client.Error].
+type outputError struct {
+	Message      string         `json:"message,omitempty"`
+	PeerAddress  netip.AddrPort `json:"peerAddress,omitzero"`
+	PacketLength int            `json:"packetLength,omitempty"`
+	Error        string         `json:"error"`
+}
+
+// resultFromGet converts the return values of [client.Client.GetResult] to a result.
+func resultFromGet(result client.Result, err error) client.Result {
+	if err == nil {
+		return result
+	}
+	serverAddrPort := result.ServerAddrPort
+	var clientErr client.Error
+	if errors.As(err, &clientErr) {
+		result = client.ErrResult(clientErr)
+	} else {
+		result = client.ErrResult(client.Error{Message: "failed to get client address", PeerAddrPort: serverAddrPort, Err: err})
+	}
+	result.ServerAddrPort = serverAddrPort
+	return result
+}
+
+// resultWriter writes client results in the configured format.
+type resultWriter struct {
+	format outputFormat
+	stdout io.Writer
+	stderr io.Writer
+	logger *zap.Logger
+}
+
+// Write writes the result.
+func (w *resultWriter) Write(result client.Result) error {
+	switch w.format {
+	case outputPlain:
+		if result.IsOk() {
+			_, err := fmt.Fprintln(w.stdout, result.ClientAddrPort)
+			return err
+		}
+		_, err := fmt.Fprintln(w.stderr, result.Err)
+		return err
+
+	case outputJSON, outputJSONL:
+		enc := json.NewEncoder(w.stdout)
+		if w.format == outputJSON {
+			enc.SetIndent("", "    ")
+		}
+		return enc.Encode(newOutputResult(result, time.Now()))
+
+	case outputEnv:
+		_, err := io.WriteString(w.stdout, envOutput(result))
+		return err
+
+	default:
+		if result.IsOk() {
+			w.logger.Info("Got client address",
+				zap.Stringer("serverAddress", result.ServerAddrPort),
+				zap.Stringer("clientAddress", result.ClientAddrPort),
+			)
+		} else {
+			w.logger.Warn("Failed to get client address", zap.Error(result.Err))
+		}
+		return nil
+	}
+}
+
+// newOutputResult returns the JSON representation of the result.
+func newOutputResult(result client.Result, t time.Time) outputResult {
+	if result.IsOk() {
+		return outputResult{
+			Time:          t,
+			OK:            true,
+			Server:        result.ServerAddrPort,
+			ClientAddress: result.ClientAddrPort,
+			Address:       result.ClientAddrPort.Addr(),
+			Port:          result.ClientAddrPort.Port(),
+		}
+	}
+	return outputResult{
+		Time:   t,
+		Server: result.ServerAddrPort,
+		Error:  newOutputError(result.Err),
+	}
+}
+
+// newOutputError returns the JSON representation of the error.
+func newOutputError(err client.Error) *outputError {
+	oe := outputError{
+		Message:      err.Message,
+		PeerAddress:  err.PeerAddrPort,
+		PacketLength: err.PacketLength,
+	}
+	if err.Err != nil {
+		oe.Error = err.Err.Error()
+	}
+	return &oe
+}
+
+// envOutput returns the result as shell variable assignments, one per line.
+// Results in a stream are separated by an empty line.
+func envOutput(result client.Result) string {
+	var b strings.Builder
+	writeEnvResult(&b, "OPDT_", result)
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// writeEnvResult writes the result as shell variable assignments with the given name prefix.
+func writeEnvResult(b *strings.Builder, prefix string, result client.Result) {
+	if result.IsOk() {
+		fmt.Fprintf(b, "%sOK=1\n%sADDR=%s\n%sPORT=%d\n", prefix, prefix, result.ClientAddrPort.Addr(), prefix, result.ClientAddrPort.Port())
+		if result.ServerAddrPort.IsValid() {
+			fmt.Fprintf(b, "%sSERVER=%s\n", prefix, result.ServerAddrPort)
+		}
+		return
+	}
+	oe := newOutputError(result.Err)
+	fmt.Fprintf(b, "%sOK=0\n", prefix)
+	if result.ServerAddrPort.IsValid() {
+		fmt.Fprintf(b, "%sSERVER=%s\n", prefix, result.ServerAddrPort)
+	}
+	fmt.Fprintf(b, "%sERROR=%s\n", prefix, shellQuote(oe.Error))
+	fmt.Fprintf(b, "%sERROR_MESSAGE=%s\n", prefix, shellQuote(oe.Message))
+	if oe.PeerAddress.IsValid() {
+		fmt.Fprintf(b, "%sERROR_PEER=%s\n", prefix, oe.PeerAddress)
+	}
+	fmt.Fprintf(b, "%sERROR_PACKET_LENGTH=%d\n", prefix, oe.PacketLength)
+}
+
+// shellQuote quotes s for safe use in a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// outputEvent is the JSON representation of a watch event.
+type outputEvent struct {
+	Time       time.Time        `json:"time"`
+	Event      client.EventType `json:"event"`
+	OldAddress netip.AddrPort   `json:"oldAddress,omitzero"`
+	NewAddress netip.AddrPort   `json:"newAddress,omitzero"`
+	Failures   int              `json:"failures,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+// WriteEvent writes the watch event.
+func (w *resultWriter) WriteEvent(event client.Event) error {
+	switch w.format {
+	case outputPlain:
+		if event.Type == client.EventDown {
+			if event.Err != nil {
+				_, err := fmt.Fprintf(w.stderr, "down: no response for %d intervals: %v\n", event.Failures, event.Err)
+				return err
+			}
+			_, err := fmt.Fprintf(w.stderr, "down: no response for %d intervals\n", event.Failures)
+			return err
+		}
+		_, err := fmt.Fprintln(w.stdout, event.NewAddrPort)
+		return err
+
+	case outputJSON, outputJSONL:
+		enc := json.NewEncoder(w.stdout)
+		if w.format == outputJSON {
+			enc.SetIndent("", "    ")
+		}
+		oe := outputEvent{
+			Time:       event.Time,
+			Event:      event.Type,
+			OldAddress: event.OldAddrPort,
+			NewAddress: event.NewAddrPort,
+			Failures:   event.Failures,
+		}
+		if event.Err != nil {
+			oe.Error = event.Err.Error()
+		}
+		return enc.Encode(oe)
+
+	case outputEnv:
+		var b strings.Builder
+		for _, kv := range event.Env() {
+			key, value, _ := strings.Cut(kv, "=")
+			fmt.Fprintf(&b, "%s=%s\n", key, shellQuote(value))
+		}
+		b.WriteByte('\n')
+		_, err := io.WriteString(w.stdout, b.String())
+		return err
+
+	default:
+		fields := []zap.Field{
+			zap.Stringer("oldClientAddress", event.OldAddrPort),
+			zap.Stringer("newClientAddress", event.NewAddrPort),
+		}
+		switch event.Type {
+		case client.EventChanged:
+			w.logger.Info("Client address changed", fields...)
+		case client.EventUp:
+			w.logger.Info("Path is up", fields...)
+		case client.EventDown:
+			w.logger.Warn("Path is down",
+				zap.Stringer("clientAddress", event.OldAddrPort),
+				zap.Int("failures", event.Failures),
+				zap.NamedError("lastError", event.Err),
+			)
+		}
+		return nil
+	}
+}
+
+// outputDualStackResult is the JSON representation of a dual-stack result.
+type outputDualStackResult struct {
+	IPv4 outputResult `json:"ipv4"`
+	IPv6 outputResult `json:"ipv6"`
+}
+
+// WriteDualStack writes the result of each address family.
+func (w *resultWriter) WriteDualStack(result client.DualStackResult) error {
+	families := [...]struct {
+		name   string
+		result client.Result
+	}{
+		{"ipv4", result.IPv4},
+		{"ipv6", result.IPv6},
+	}
+
+	switch w.format {
+	case outputPlain:
+		for _, f := range families {
+			if f.result.IsOk() {
+				if _, err := fmt.Fprintln(w.stdout, f.name, f.result.ClientAddrPort); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := fmt.Fprintf(w.stderr, "%s: %v\n", f.name, f.result.Err); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case outputJSON, outputJSONL:
+		enc := json.NewEncoder(w.stdout)
+		if w.format == outputJSON {
+			enc.SetIndent("", "    ")
+		}
+		now := time.Now()
+		return enc.Encode(outputDualStackResult{
+			IPv4: newOutputResult(result.IPv4, now),
+			IPv6: newOutputResult(result.IPv6, now),
+		})
+
+	case outputEnv:
+		var b strings.Builder
+		writeEnvResult(&b, "OPDT_IPV4_", result.IPv4)
+		writeEnvResult(&b, "OPDT_IPV6_", result.IPv6)
+		b.WriteByte('\n')
+		_, err := io.WriteString(w.stdout, b.String())
+		return err
+
+	default:
+		for _, f := range families {
+			if f.result.IsOk() {
+				w.logger.Info("Got client address",
+					zap.String("family", f.name),
+					zap.Stringer("clientAddress", f.result.ClientAddrPort),
+				)
+			} else {
+				w.logger.Warn("Failed to get client address",
+					zap.String("family", f.name),
+					zap.Error(f.result.Err),
+				)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/database64128/opdt-go/server"
+	"github.com/database64128/opdt-go/systemd"
+	"go.uber.org/zap"
+)
+
+// defaultServerConfPath is the default path of the server config file.
+const defaultServerConfPath = "/etc/opdt-go/config.json"
+
+func runServerCommand(name string, args []string) {
+	var (
+		confPath    string
+		fingerprint bool
+		lf          loggingFlags
+	)
+
+	fs := newFlagSet(name, "[flags]", "Run the servers of the config file. Send SIGHUP to reload the config file.")
+	fs.StringVar(&confPath, "conf", defaultServerConfPath, "Path to the server config file")
+	fs.BoolVar(&fingerprint, "fingerprint", false, "Print the fingerprint of each configured key and exit, so that the keys of clients and servers can be checked to match without revealing them")
+	lf.register(fs)
+	_ = fs.Parse(args)
+
+	logger, atomicLevel := lf.newLogger()
+	defer logger.Sync()
+
+	sc, legacy, err := loadServerConfig(confPath)
+	if err != nil {
+		logger.Fatal("Failed to load server config",
+			zap.String("path", confPath),
+			zap.Error(err),
+		)
+	}
+	if legacy {
+		logger.Warn(legacyServerConfigWarning, zap.String("path", confPath))
+	}
+
+	if !logProblems(logger, "server", sc.Validate()) {
+		logger.Fatal("Invalid server config", zap.String("path", confPath))
+	}
+
+	if fingerprint {
+		if err := writeServerFingerprints(os.Stdout, &sc); err != nil {
+			logger.Fatal("Failed to load keys", zap.Error(err))
+		}
+		return
+	}
+
+	// Handle signals before readiness is reported, as systemd may send SIGHUP right after,
+	// and the default action of SIGHUP terminates the process.
+	ctx := signalContext()
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+
+	m, err := sc.Manager(logger, atomicLevel)
+	if err != nil {
+		logger.Fatal("Failed to initialize servers", zap.Error(err))
+	}
+
+	notifier, err := systemd.NewNotifier()
+	if err != nil {
+		logger.Warn("Failed to connect to systemd notify socket", zap.Error(err))
+	}
+	defer notifier.Close()
+
+	if err = m.Start(ctx); err != nil {
+		logger.Fatal("Failed to start servers", zap.Error(err))
+	}
+
+	if err = notifier.Notify(systemd.StateReady + "\nSTATUS=" + m.Stats().String()); err != nil {
+		logger.Warn("Failed to notify systemd", zap.Error(err))
+	}
+
+	var notifyWg sync.WaitGroup
+	if notifier != nil {
+		notifyWg.Go(func() {
+			notifySystemd(ctx, notifier, m, logger)
+		})
+	}
+
+serverLoop:
+	for {
+		select {
+		case <-ctx.Done():
+			break serverLoop
+		case <-hupCh:
+			logger.Info("Reloading server config", zap.String("path", confPath))
+
+			sc, legacy, err := loadServerConfig(confPath)
+			if err != nil {
+				logger.Error("Failed to load server config",
+					zap.String("path", confPath),
+					zap.Error(err),
+				)
+				continue
+			}
+			if legacy {
+				logger.Warn(legacyServerConfigWarning, zap.String("path", confPath))
+			}
+
+			if !logProblems(logger, "server", sc.Validate()) {
+				logger.Error("Invalid server config, keeping the current config", zap.String("path", confPath))
+				continue
+			}
+
+			if err = m.Reload(ctx, sc); err != nil {
+				logger.Error("Failed to reload server config", zap.Error(err))
+				continue
+			}
+
+			logger.Info("Reloaded server config")
+		}
+	}
+
+	signal.Stop(hupCh)
+
+	if err = notifier.Notify(systemd.StateStopping); err != nil {
+		logger.Warn("Failed to notify systemd", zap.Error(err))
+	}
+	notifyWg.Wait()
+	m.Stop()
+}
+
+// legacyServerConfigWarning is the warning about a server config in the legacy single-server format.
+const legacyServerConfigWarning = `Server config uses the deprecated top-level "listen" and "psk" settings, move them into "servers": [{"listen": ..., "keys": [{"psk": ...}]}]`
+
+// loadServerConfig loads the server config file at path.
+// A config in the legacy single-server format is converted into a single server instance,
+// and legacy is set to true.
+func loadServerConfig(path string) (sc server.Config, legacy bool, err error) {
+	if err = decodeConfigFile(path, &sc); err != nil {
+		return server.Config{}, false, err
+	}
+	return sc, sc.UpgradeLegacy(), nil
+}
+
+// systemdStatusInterval is the interval between status updates sent to systemd.
+const systemdStatusInterval = 10 * time.Second
+
+// notifySystemd sends the request counters of the servers to systemd until ctx is canceled.
+//
+// If the watchdog is enabled, it also pings the watchdog at half the timeout,
+// as long as the receive loops of all servers make progress,
+// so that systemd restarts the service when a receive loop gets stuck.
+func notifySystemd(ctx context.Context, n *systemd.Notifier, m *server.Manager, logger *zap.Logger) {
+	statusTicker := time.NewTicker(systemdStatusInterval)
+	defer statusTicker.Stop()
+
+	timeout, err := systemd.WatchdogTimeout()
+	if err != nil {
+		logger.Warn("Failed to get systemd watchdog timeout", zap.Error(err))
+	}
+
+	var watchdogCh <-chan time.Time
+	if timeout > 0 {
+		watchdogTicker := time.NewTicker(timeout / 2)
+		defer watchdogTicker.Stop()
+		watchdogCh = watchdogTicker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-statusTicker.C:
+			if err = n.Status(m.Stats().String()); err != nil {
+				logger.Warn("Failed to notify systemd", zap.Error(err))
+			}
+
+		case <-watchdogCh:
+			if stalled := m.StalledServers(); len(stalled) > 0 {
+				logger.Error("Receive loop made no progress, skipping watchdog ping", zap.Strings("servers", stalled))
+				continue
+			}
+			if err = n.Notify(systemd.StateWatchdog); err != nil {
+				logger.Warn("Failed to notify systemd", zap.Error(err))
+			}
+		}
+	}
+}
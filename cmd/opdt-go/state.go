@@ -0,0 +1,57 @@
+package main
+
+import (
+	"time"
+
+	"github.com/database64128/opdt-go/client"
+	"go.uber.org/zap"
+)
+
+// stateRefreshInterval is the maximum interval between writes of the state file
+// when only the confirmation time changes.
+const stateRefreshInterval = time.Minute
+
+// stateWriter keeps the state file up to date with results.
+type stateWriter struct {
+	path      string
+	state     client.State
+	lastSaved time.Time
+	logger    *zap.Logger
+}
+
+// newStateWriter loads the state file at path, and returns a writer that updates it.
+func newStateWriter(path string, serverAddress string, logger *zap.Logger) (*stateWriter, error) {
+	state, err := client.LoadState(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// A mapping discovered with another server is not comparable.
+	if state.Server != serverAddress {
+		state = client.State{Server: serverAddress}
+	}
+
+	return &stateWriter{
+		path:   path,
+		state:  state,
+		logger: logger,
+	}, nil
+}
+
+// Write updates the state with the result, and saves it if the mapping or error changed,
+// or if it has not been saved for stateRefreshInterval.
+func (w *stateWriter) Write(result client.Result) {
+	now := time.Now()
+	if !w.state.Update(result, now) && now.Sub(w.lastSaved) < stateRefreshInterval {
+		return
+	}
+
+	if err := w.state.Save(w.path); err != nil {
+		w.logger.Warn("Failed to save state file",
+			zap.String("path", w.path),
+			zap.Error(err),
+		)
+		return
+	}
+	w.lastSaved = now
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/database64128/opdt-go/client"
+	"go.uber.org/zap"
+)
+
+// webhookQueueSize is the number of payloads queued for each webhook.
+// Payloads are dropped when a webhook falls behind.
+const webhookQueueSize = 64
+
+// hookQueueSize is the number of events queued for each hook.
+// Events are dropped when a hook falls behind.
+const hookQueueSize = 64
+
+// stringSliceFlag is a flag that can be specified multiple times.
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringSliceFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// eventHandler writes watch events, runs hooks, and notifies webhooks.
+type eventHandler struct {
+	writer     *resultWriter
+	hooks      []hookQueue
+	webhooks   []webhookQueue
+	clientName string
+	logger     *zap.Logger
+	wg         sync.WaitGroup
+}
+
+// webhookQueue delivers payloads to a webhook in order.
+type webhookQueue struct {
+	webhook *client.Webhook
+	ch      chan client.WebhookPayload
+}
+
+// hookQueue runs a hook for events in order.
+type hookQueue struct {
+	hook *client.Hook
+	ch   chan client.Event
+}
+
+// newEventHandler returns a new event handler, and starts a goroutine for each hook and webhook.
+// Hooks and deliveries stop when ctx is canceled.
+func newEventHandler(ctx context.Context, writer *resultWriter, hooks []client.Hook, webhooks []client.Webhook, clientName string, logger *zap.Logger) *eventHandler {
+	h := &eventHandler{
+		writer:     writer,
+		hooks:      make([]hookQueue, len(hooks)),
+		webhooks:   make([]webhookQueue, len(webhooks)),
+		clientName: clientName,
+		logger:     logger,
+	}
+
+	for i := range hooks {
+		q := hookQueue{
+			hook: &hooks[i],
+			ch:   make(chan client.Event, hookQueueSize),
+		}
+		h.hooks[i] = q
+
+		h.wg.Go(func() {
+			for event := range q.ch {
+				if err := q.hook.Run(ctx, event); err != nil {
+					logger.Warn("Failed to run watch hook",
+						zap.String("command", q.hook.Command),
+						zap.String("event", string(event.Type)),
+						zap.Error(err),
+					)
+				}
+			}
+		})
+	}
+
+	for i := range webhooks {
+		q := webhookQueue{
+			webhook: &webhooks[i],
+			ch:      make(chan client.WebhookPayload, webhookQueueSize),
+		}
+		h.webhooks[i] = q
+
+		h.wg.Go(func() {
+			for payload := range q.ch {
+				if err := q.webhook.Deliver(ctx, payload); err != nil {
+					logger.Warn("Failed to deliver webhook",
+						zap.String("url", q.webhook.URL),
+						zap.String("event", string(payload.Event)),
+						zap.Error(err),
+					)
+				}
+			}
+		})
+	}
+
+	return h
+}
+
+// Handle writes the event, and queues it for hooks and webhooks.
+func (h *eventHandler) Handle(event client.Event) error {
+	if err := h.writer.WriteEvent(event); err != nil {
+		return err
+	}
+
+	for _, q := range h.hooks {
+		select {
+		case q.ch <- event:
+		default:
+			h.logger.Warn("Dropped watch hook run, queue is full",
+				zap.String("command", q.hook.Command),
+				zap.String("event", string(event.Type)),
+			)
+		}
+	}
+
+	for _, q := range h.webhooks {
+		if !q.webhook.Wants(event.Type) {
+			continue
+		}
+		select {
+		case q.ch <- client.NewWebhookPayload(event, h.clientName):
+		default:
+			h.logger.Warn("Dropped webhook delivery, queue is full",
+				zap.String("url", q.webhook.URL),
+				zap.String("event", string(event.Type)),
+			)
+		}
+	}
+
+	return nil
+}
+
+// Close waits for queued hooks and webhook deliveries to finish.
+func (h *eventHandler) Close() {
+	for _, q := range h.hooks {
+		close(q.ch)
+	}
+	for _, q := range h.webhooks {
+		close(q.ch)
+	}
+	h.wg.Wait()
+}
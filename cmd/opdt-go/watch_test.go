@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/netip"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/database64128/opdt-go/client"
+	"go.uber.org/zap"
+)
+
+func TestEventHandlerSlowHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The test hook uses sleep, which is not available on Windows")
+	}
+
+	const hookDuration = 2 * time.Second
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	w := resultWriter{
+		format: outputPlain,
+		stdout: io.Discard,
+		stderr: io.Discard,
+		logger: zap.NewNop(),
+	}
+	hooks := []client.Hook{{Command: "sleep 2"}}
+	h := newEventHandler(ctx, &w, hooks, nil, "test", zap.NewNop())
+
+	start := time.Now()
+	for _, addrPort := range []netip.AddrPort{
+		netip.MustParseAddrPort("[2001:db8::1]:20220"),
+		netip.MustParseAddrPort("[2001:db8::2]:20220"),
+	} {
+		if err := h.Handle(client.Event{
+			Type:        client.EventChanged,
+			Time:        time.Now(),
+			NewAddrPort: addrPort,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= hookDuration {
+		t.Errorf("Handling two events took %s, expected the slow hook not to delay the next event", elapsed)
+	}
+
+	// Canceling the context stops the running hook, so that Close does not wait for it.
+	cancel()
+	h.Close()
+}
@@ -0,0 +1,8 @@
+package conn
+
+import "errors"
+
+var (
+	ErrMessageTruncated        = errors.New("the packet is larger than the supplied buffer")
+	ErrControlMessageTruncated = errors.New("the control message is larger than the supplied buffer")
+)
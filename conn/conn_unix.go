@@ -2,16 +2,7 @@
 
 package conn
 
-import (
-	"errors"
-
-	"golang.org/x/sys/unix"
-)
-
-var (
-	ErrMessageTruncated        = errors.New("the packet is larger than the supplied buffer")
-	ErrControlMessageTruncated = errors.New("the control message is larger than the supplied buffer")
-)
+import "golang.org/x/sys/unix"
 
 // ParseFlagsForError parses the message flags returned by
 // the ReadMsgUDPAddrPort method and returns an error if MSG_TRUNC
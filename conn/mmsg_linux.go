@@ -0,0 +1,79 @@
+package conn
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Mmsghdr is the Go representation of struct mmsghdr.
+type Mmsghdr struct {
+	Msghdr unix.Msghdr
+	Msglen uint32
+}
+
+// Recvmmsg receives up to len(msgvec) messages from the socket in a single system call.
+// It returns the number of messages received.
+//
+// The received length of each message is stored in its Msglen field,
+// and the message flags in Msghdr.Flags. Use [ParseFlagsForError] to check each message for truncation.
+func Recvmmsg(fd int, msgvec []Mmsghdr, flags int) (int, error) {
+	r0, _, e1 := unix.Syscall6(unix.SYS_RECVMMSG, uintptr(fd), uintptr(unsafe.Pointer(unsafe.SliceData(msgvec))), uintptr(len(msgvec)), uintptr(flags), 0, 0)
+	if e1 != 0 {
+		return 0, e1
+	}
+	return int(r0), nil
+}
+
+// Sendmmsg sends up to len(msgvec) messages on the socket in a single system call.
+// It returns the number of messages sent.
+func Sendmmsg(fd int, msgvec []Mmsghdr, flags int) (int, error) {
+	r0, _, e1 := unix.Syscall6(unix.SYS_SENDMMSG, uintptr(fd), uintptr(unsafe.Pointer(unsafe.SliceData(msgvec))), uintptr(len(msgvec)), uintptr(flags), 0, 0)
+	if e1 != 0 {
+		return 0, e1
+	}
+	return int(r0), nil
+}
+
+// AddrPortFromSockaddr returns the address and port stored in the socket address.
+// The socket address may either be a [unix.RawSockaddrInet4] or a [unix.RawSockaddrInet6].
+//
+// It returns the zero value if the socket address is neither AF_INET nor AF_INET6.
+func AddrPortFromSockaddr(rsa *unix.RawSockaddrInet6, namelen uint32) netip.AddrPort {
+	switch {
+	case rsa.Family == unix.AF_INET && namelen >= unix.SizeofSockaddrInet4:
+		rsa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		return netip.AddrPortFrom(netip.AddrFrom4(rsa4.Addr), portFromNetworkOrder(rsa4.Port))
+	case rsa.Family == unix.AF_INET6 && namelen >= unix.SizeofSockaddrInet6:
+		return netip.AddrPortFrom(netip.AddrFrom16(rsa.Addr), portFromNetworkOrder(rsa.Port))
+	default:
+		return netip.AddrPort{}
+	}
+}
+
+// PutSockaddr writes addrPort into rsa as an AF_INET6 socket address, and returns the socket address length.
+// IPv4 addresses are converted to IPv4-mapped IPv6 addresses.
+func PutSockaddr(rsa *unix.RawSockaddrInet6, addrPort netip.AddrPort) uint32 {
+	*rsa = unix.RawSockaddrInet6{
+		Family: unix.AF_INET6,
+		Addr:   addrPort.Addr().As16(),
+	}
+	rsa.Port = portToNetworkOrder(addrPort.Port())
+	return unix.SizeofSockaddrInet6
+}
+
+// portFromNetworkOrder converts a port number stored in network byte order to host byte order.
+func portFromNetworkOrder(port uint16) uint16 {
+	var b [2]byte
+	binary.NativeEndian.PutUint16(b[:], port)
+	return binary.BigEndian.Uint16(b[:])
+}
+
+// portToNetworkOrder converts a port number in host byte order to network byte order.
+func portToNetworkOrder(port uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], port)
+	return binary.NativeEndian.Uint16(b[:])
+}
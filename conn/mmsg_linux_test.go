@@ -0,0 +1,154 @@
+package conn
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRecvmmsgSendmmsg(t *testing.T) {
+	const batchSize = 8
+
+	receiver, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+
+	sender, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	receiverAddrPort := receiver.LocalAddr().(*net.UDPAddr).AddrPort()
+	senderAddrPort := sender.LocalAddr().(*net.UDPAddr).AddrPort()
+
+	// Send a batch of messages, with the last one larger than the receive buffer.
+	var (
+		name     unix.RawSockaddrInet6
+		payloads [batchSize][]byte
+		iovs     [batchSize]unix.Iovec
+		smsgvec  [batchSize]Mmsghdr
+	)
+	namelen := PutSockaddr(&name, receiverAddrPort)
+	for i := range smsgvec {
+		payloads[i] = bytes.Repeat([]byte{byte(i)}, 16)
+		iovs[i].Base = &payloads[i][0]
+		iovs[i].SetLen(len(payloads[i]))
+		smsgvec[i].Msghdr.Name = (*byte)(unsafe.Pointer(&name))
+		smsgvec[i].Msghdr.Namelen = namelen
+		smsgvec[i].Msghdr.Iov = &iovs[i]
+		smsgvec[i].Msghdr.SetIovlen(1)
+	}
+	payloads[batchSize-1] = bytes.Repeat([]byte{batchSize - 1}, 32)
+	iovs[batchSize-1].Base = &payloads[batchSize-1][0]
+	iovs[batchSize-1].SetLen(32)
+
+	senderRawConn, err := sender.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		sent    int
+		sendErr error
+	)
+	if err = senderRawConn.Write(func(fd uintptr) bool {
+		sent, sendErr = Sendmmsg(int(fd), smsgvec[:], 0)
+		return sendErr != unix.EAGAIN
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if sendErr != nil {
+		t.Fatal(sendErr)
+	}
+	if sent != batchSize {
+		t.Fatalf("Sent %d messages, expected %d", sent, batchSize)
+	}
+
+	// Receive the whole batch.
+	var (
+		names   [batchSize]unix.RawSockaddrInet6
+		bufs    [batchSize][16]byte
+		rmsgvec [batchSize]Mmsghdr
+	)
+	for i := range rmsgvec {
+		iovs[i].Base = &bufs[i][0]
+		iovs[i].SetLen(len(bufs[i]))
+		rmsgvec[i].Msghdr.Name = (*byte)(unsafe.Pointer(&names[i]))
+		rmsgvec[i].Msghdr.Namelen = unix.SizeofSockaddrInet6
+		rmsgvec[i].Msghdr.Iov = &iovs[i]
+		rmsgvec[i].Msghdr.SetIovlen(1)
+	}
+
+	receiverRawConn, err := receiver.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		received int
+		recvErr  error
+	)
+	for total := 0; total < batchSize; total += received {
+		if err = receiverRawConn.Read(func(fd uintptr) bool {
+			received, recvErr = Recvmmsg(int(fd), rmsgvec[total:], 0)
+			return recvErr != unix.EAGAIN
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if recvErr != nil {
+			t.Fatal(recvErr)
+		}
+	}
+
+	for i := range rmsgvec {
+		msg := &rmsgvec[i]
+		if addrPort := AddrPortFromSockaddr(&names[i], msg.Msghdr.Namelen); addrPort != senderAddrPort {
+			t.Errorf("Message %d: got source address %s, expected %s", i, addrPort, senderAddrPort)
+		}
+
+		err = ParseFlagsForError(int(msg.Msghdr.Flags))
+		if i == batchSize-1 {
+			if err != ErrMessageTruncated {
+				t.Errorf("Message %d: got error %v, expected %v", i, err, ErrMessageTruncated)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Message %d: unexpected error: %v", i, err)
+		}
+		if got := bufs[i][:msg.Msglen]; !bytes.Equal(got, payloads[i]) {
+			t.Errorf("Message %d: got payload %v, expected %v", i, got, payloads[i])
+		}
+	}
+}
+
+func TestAddrPortFromSockaddr(t *testing.T) {
+	for _, addrPort := range []netip.AddrPort{
+		netip.MustParseAddrPort("[2001:db8::1]:30720"),
+		netip.MustParseAddrPort("[::ffff:192.0.2.1]:443"),
+	} {
+		var rsa unix.RawSockaddrInet6
+		namelen := PutSockaddr(&rsa, addrPort)
+		if got := AddrPortFromSockaddr(&rsa, namelen); got != addrPort {
+			t.Errorf("Got %s, expected %s", got, addrPort)
+		}
+		if got, want := *(*[2]byte)(unsafe.Pointer(&rsa.Port)), [2]byte{byte(addrPort.Port() >> 8), byte(addrPort.Port())}; got != want {
+			t.Errorf("Got port bytes %v, expected %v", got, want)
+		}
+	}
+
+	var rsa unix.RawSockaddrInet6
+	rsa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(&rsa))
+	rsa4.Family = unix.AF_INET
+	rsa4.Port = portToNetworkOrder(20220)
+	rsa4.Addr = [4]byte{192, 0, 2, 1}
+	want := netip.MustParseAddrPort("192.0.2.1:20220")
+	if got := AddrPortFromSockaddr(&rsa, unix.SizeofSockaddrInet4); got != want {
+		t.Errorf("Got %s, expected %s", got, want)
+	}
+}
@@ -0,0 +1,358 @@
+// Package dnsupdate keeps DNS records in sync with the client address
+// by sending RFC 2136 dynamic updates, optionally signed with TSIG.
+package dnsupdate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/database64128/opdt-go/jsonhelper"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	defaultTTL     = 60
+	defaultTimeout = 5 * time.Second
+)
+
+// opCodeUpdate is the DNS UPDATE opcode.
+const opCodeUpdate dnsmessage.OpCode = 5
+
+var (
+	ErrUpdateFailed    = errors.New("DNS update failed")
+	ErrNothingToUpdate = errors.New("neither address records nor SRV record configured")
+)
+
+// SRVConfig is the configuration of an SRV record that carries the client port.
+type SRVConfig struct {
+	// Name is the owner name of the SRV record, such as "_game._udp.example.com".
+	Name string `json:"name"`
+
+	// Target is the target host name of the SRV record.
+	// If empty, the name of the address records is used.
+	Target string `json:"target,omitempty"`
+
+	Priority uint16 `json:"priority,omitempty"`
+	Weight   uint16 `json:"weight,omitempty"`
+
+	// TTL is the TTL of the SRV record in seconds.
+	// If zero, the TTL of the address records is used.
+	TTL uint32 `json:"ttl,omitempty"`
+}
+
+// Config is the configuration of a DNS updater.
+type Config struct {
+	// Server is the address of the authoritative DNS server, in the form "host:port".
+	// If the port is omitted, port 53 is used.
+	Server string `json:"server"`
+
+	// Network is the transport used to send updates, "udp" or "tcp".
+	// If empty, "udp" is used.
+	Network string `json:"network,omitempty"`
+
+	// Zone is the zone that contains the records.
+	Zone string `json:"zone"`
+
+	// Name is the owner name of the A or AAAA record set to the client IP address.
+	// If empty, no address record is updated.
+	Name string `json:"name,omitempty"`
+
+	// TTL is the TTL of the address records in seconds.
+	// If zero, 60 seconds is used.
+	TTL uint32 `json:"ttl,omitempty"`
+
+	// SRV is the configuration of the SRV record set to the client port.
+	// If nil, no SRV record is updated.
+	SRV *SRVConfig `json:"srv,omitempty"`
+
+	// TSIG is the key used to sign updates.
+	// If nil, updates are not signed.
+	TSIG *TSIGKey `json:"tsig,omitempty"`
+
+	// Timeout is the timeout of each update.
+	// If zero, updates time out after 5 seconds.
+	Timeout jsonhelper.Duration `json:"timeout,omitempty"`
+}
+
+// Updater sends dynamic updates to an authoritative DNS server.
+type Updater struct {
+	server    string
+	network   string
+	zone      dnsmessage.Name
+	name      dnsmessage.Name
+	ttl       uint32
+	srvName   dnsmessage.Name
+	srvTarget dnsmessage.Name
+	srv       *SRVConfig
+	srvTTL    uint32
+	tsig      *TSIGKey
+	timeout   time.Duration
+}
+
+// Updater validates the configuration and returns a new updater.
+func (c Config) Updater() (*Updater, error) {
+	if c.Name == "" && c.SRV == nil {
+		return nil, ErrNothingToUpdate
+	}
+
+	u := Updater{
+		server:  c.Server,
+		network: c.Network,
+		ttl:     c.TTL,
+		srv:     c.SRV,
+		tsig:    c.TSIG,
+		timeout: time.Duration(c.Timeout),
+	}
+
+	if _, _, err := net.SplitHostPort(u.server); err != nil {
+		u.server = net.JoinHostPort(u.server, "53")
+	}
+
+	switch u.network {
+	case "":
+		u.network = "udp"
+	case "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("unknown network %q", c.Network)
+	}
+
+	if u.ttl == 0 {
+		u.ttl = defaultTTL
+	}
+	if u.timeout == 0 {
+		u.timeout = defaultTimeout
+	}
+
+	var err error
+	if u.zone, err = dnsmessage.NewName(canonicalName(c.Zone)); err != nil {
+		return nil, fmt.Errorf("bad zone %q: %w", c.Zone, err)
+	}
+	if c.Name != "" {
+		if u.name, err = dnsmessage.NewName(canonicalName(c.Name)); err != nil {
+			return nil, fmt.Errorf("bad name %q: %w", c.Name, err)
+		}
+	}
+
+	if c.SRV != nil {
+		if u.srvName, err = dnsmessage.NewName(canonicalName(c.SRV.Name)); err != nil {
+			return nil, fmt.Errorf("bad SRV name %q: %w", c.SRV.Name, err)
+		}
+		target := c.SRV.Target
+		if target == "" {
+			target = c.Name
+		}
+		if target == "" {
+			return nil, errors.New("SRV target is empty, and no name is configured")
+		}
+		if u.srvTarget, err = dnsmessage.NewName(canonicalName(target)); err != nil {
+			return nil, fmt.Errorf("bad SRV target %q: %w", target, err)
+		}
+		u.srvTTL = c.SRV.TTL
+		if u.srvTTL == 0 {
+			u.srvTTL = u.ttl
+		}
+	}
+
+	if u.tsig != nil {
+		if _, err = u.tsig.newHash(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &u, nil
+}
+
+// Update replaces the address record of the client address family with the client IP address,
+// and the SRV record with one that points to the client port.
+func (u *Updater) Update(ctx context.Context, clientAddrPort netip.AddrPort) error {
+	var idBuf [2]byte
+	rand.Read(idBuf[:])
+	id := binary.BigEndian.Uint16(idBuf[:])
+
+	msg, err := u.buildMessage(id, clientAddrPort)
+	if err != nil {
+		return err
+	}
+
+	var requestMAC []byte
+	if u.tsig != nil {
+		if msg, requestMAC, err = u.tsig.Sign(msg, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	resp, err := u.exchange(ctx, id, msg)
+	if err != nil {
+		return err
+	}
+
+	var p dnsmessage.Parser
+	header, err := p.Start(resp)
+	if err != nil {
+		return err
+	}
+	if header.RCode != dnsmessage.RCodeSuccess {
+		return fmt.Errorf("%w: %s", ErrUpdateFailed, header.RCode)
+	}
+
+	if u.tsig != nil {
+		if err = u.tsig.VerifyResponse(resp, requestMAC, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// buildMessage builds the unsigned update message.
+func (u *Updater) buildMessage(id uint16, clientAddrPort netip.AddrPort) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{
+		ID:     id,
+		OpCode: opCodeUpdate,
+	})
+
+	// Zone section.
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{
+		Name:  u.zone,
+		Type:  dnsmessage.TypeSOA,
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		return nil, err
+	}
+
+	// Update section.
+	if err := b.StartAuthorities(); err != nil {
+		return nil, err
+	}
+
+	if u.name.Length > 0 {
+		addr := clientAddrPort.Addr().Unmap()
+		addrType := dnsmessage.TypeAAAA
+		if addr.Is4() {
+			addrType = dnsmessage.TypeA
+		}
+
+		// Delete the RRset.
+		if err := b.UnknownResource(dnsmessage.ResourceHeader{
+			Name:  u.name,
+			Class: dnsmessage.ClassANY,
+		}, dnsmessage.UnknownResource{Type: addrType}); err != nil {
+			return nil, err
+		}
+
+		hdr := dnsmessage.ResourceHeader{
+			Name:  u.name,
+			Class: dnsmessage.ClassINET,
+			TTL:   u.ttl,
+		}
+		var err error
+		if addr.Is4() {
+			err = b.AResource(hdr, dnsmessage.AResource{A: addr.As4()})
+		} else {
+			err = b.AAAAResource(hdr, dnsmessage.AAAAResource{AAAA: addr.As16()})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if u.srv != nil {
+		if err := b.UnknownResource(dnsmessage.ResourceHeader{
+			Name:  u.srvName,
+			Class: dnsmessage.ClassANY,
+		}, dnsmessage.UnknownResource{Type: dnsmessage.TypeSRV}); err != nil {
+			return nil, err
+		}
+
+		if err := b.SRVResource(dnsmessage.ResourceHeader{
+			Name:  u.srvName,
+			Class: dnsmessage.ClassINET,
+			TTL:   u.srvTTL,
+		}, dnsmessage.SRVResource{
+			Priority: u.srv.Priority,
+			Weight:   u.srv.Weight,
+			Port:     clientAddrPort.Port(),
+			Target:   u.srvTarget,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return b.Finish()
+}
+
+// exchange sends the message to the server and returns the response with the matching ID.
+func (u *Updater) exchange(ctx context.Context, id uint16, msg []byte) ([]byte, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, u.network, u.server)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = c.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	stop := context.AfterFunc(ctx, func() {
+		c.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if u.network == "tcp" {
+		return exchangeTCP(c, msg)
+	}
+
+	if _, err = c.Write(msg); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, err := c.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Ignore stray and truncated responses.
+		if n >= headerLen && binary.BigEndian.Uint16(buf) == id {
+			return buf[:n], nil
+		}
+	}
+}
+
+// exchangeTCP sends the message over a stream connection, and returns the response.
+func exchangeTCP(c net.Conn, msg []byte) ([]byte, error) {
+	b := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(b, uint16(len(msg)))
+	copy(b[2:], msg)
+	if _, err := c.Write(b); err != nil {
+		return nil, err
+	}
+
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(c, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+	if _, err := io.ReadFull(c, resp); err != nil {
+		return nil, err
+	}
+	if len(resp) < headerLen || binary.BigEndian.Uint16(resp) != binary.BigEndian.Uint16(msg) {
+		return nil, fmt.Errorf("%w: response ID mismatch", ErrUpdateFailed)
+	}
+	return resp, nil
+}
@@ -0,0 +1,220 @@
+package dnsupdate
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// standInServer is a minimal authoritative server that accepts TSIG-signed updates.
+type standInServer struct {
+	conn    net.PacketConn
+	key     TSIGKey
+	updates chan []dnsmessage.Resource
+}
+
+func newStandInServer(t *testing.T, key TSIGKey) *standInServer {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	s := &standInServer{
+		conn:    conn,
+		key:     key,
+		updates: make(chan []dnsmessage.Resource, 1),
+	}
+	go s.serve(t)
+	return s
+}
+
+func (s *standInServer) serve(t *testing.T) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := buf[:n]
+
+		requestMAC, verifyErr := s.verifyRequest(req)
+
+		var p dnsmessage.Parser
+		header, err := p.Start(req)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if header.OpCode != opCodeUpdate {
+			t.Errorf("Got opcode %d, expected %d", header.OpCode, opCodeUpdate)
+		}
+		if err = p.SkipAllQuestions(); err != nil {
+			t.Error(err)
+			continue
+		}
+		if err = p.SkipAllAnswers(); err != nil {
+			t.Error(err)
+			continue
+		}
+		updates, err := p.AllAuthorities()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		rcode := dnsmessage.RCodeSuccess
+		if verifyErr != nil {
+			rcode = dnsmessage.RCode(9) // NOTAUTH
+		} else {
+			s.updates <- updates
+		}
+
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+			ID:       header.ID,
+			Response: true,
+			OpCode:   opCodeUpdate,
+			RCode:    rcode,
+		})
+		resp, err := b.Finish()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if verifyErr == nil {
+			resp = s.signResponse(resp, requestMAC)
+		}
+		if _, err = s.conn.WriteTo(resp, addr); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// verifyRequest verifies the TSIG record of the request, and returns its MAC.
+func (s *standInServer) verifyRequest(req []byte) ([]byte, error) {
+	off, err := lastRecordOffset(req)
+	if err != nil {
+		return nil, err
+	}
+	_, rdataOff, err := readName(req, off)
+	if err != nil {
+		return nil, err
+	}
+	rdata := req[rdataOff+10:]
+	_, algOff, err := readName(rdata, 0)
+	if err != nil {
+		return nil, err
+	}
+	timeSigned := readUint48(rdata[algOff:])
+	fudge := binary.BigEndian.Uint16(rdata[algOff+6:])
+	macLen := int(binary.BigEndian.Uint16(rdata[algOff+8:]))
+	mac := rdata[algOff+10 : algOff+10+macLen]
+	tail := rdata[algOff+10+macLen+2:]
+
+	unsigned := append([]byte(nil), req[:off]...)
+	binary.BigEndian.PutUint16(unsigned[10:], binary.BigEndian.Uint16(unsigned[10:])-1)
+
+	h, err := s.key.newHash()
+	if err != nil {
+		return nil, err
+	}
+	h.Write(unsigned)
+	h.Write(s.key.tsigVariables(timeSigned, fudge, tail))
+	if !hmac.Equal(h.Sum(nil), mac) {
+		return nil, ErrBadTSIG
+	}
+	return mac, nil
+}
+
+// signResponse appends a TSIG record to the response to a request signed with requestMAC.
+func (s *standInServer) signResponse(resp, requestMAC []byte) []byte {
+	h, _ := s.key.newHash()
+	timeSigned := uint64(time.Now().Unix())
+	h.Write(binary.BigEndian.AppendUint16(nil, uint16(len(requestMAC))))
+	h.Write(requestMAC)
+	h.Write(resp)
+	h.Write(s.key.tsigVariables(timeSigned, tsigFudge, noErrorNoOtherData))
+	mac := h.Sum(nil)
+
+	rdata := appendName(nil, s.key.algorithmName())
+	rdata = appendUint48(rdata, timeSigned)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(mac)))
+	rdata = append(rdata, mac...)
+	rdata = append(rdata, resp[0], resp[1])
+	rdata = append(rdata, noErrorNoOtherData...)
+
+	signed := appendName(resp, canonicalName(s.key.Name))
+	signed = binary.BigEndian.AppendUint16(signed, typeTSIG)
+	signed = binary.BigEndian.AppendUint16(signed, classANY)
+	signed = binary.BigEndian.AppendUint32(signed, 0)
+	signed = binary.BigEndian.AppendUint16(signed, uint16(len(rdata)))
+	signed = append(signed, rdata...)
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(signed[10:])+1)
+	return signed
+}
+
+func TestUpdaterUpdate(t *testing.T) {
+	key := TSIGKey{
+		Name:   "opdt-key",
+		Secret: []byte("0123456789abcdef0123456789abcdef"),
+	}
+	s := newStandInServer(t, key)
+
+	u, err := Config{
+		Server: s.conn.LocalAddr().String(),
+		Zone:   "example.com",
+		Name:   "home.example.com",
+		TTL:    30,
+		SRV: &SRVConfig{
+			Name:     "_game._udp.example.com",
+			Priority: 10,
+			Weight:   5,
+		},
+		TSIG: &key,
+	}.Updater()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientAddrPort := netip.MustParseAddrPort("203.0.113.7:40000")
+	if err = u.Update(context.Background(), clientAddrPort); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := <-s.updates
+	if len(updates) != 4 {
+		t.Fatalf("Got %d updates, expected 4", len(updates))
+	}
+
+	if h := updates[0].Header; h.Type != dnsmessage.TypeA || h.Class != dnsmessage.ClassANY || h.Length != 0 {
+		t.Errorf("Got %v, expected deletion of the A RRset", h)
+	}
+	if a, ok := updates[1].Body.(*dnsmessage.AResource); !ok || netip.AddrFrom4(a.A) != clientAddrPort.Addr() || updates[1].Header.TTL != 30 {
+		t.Errorf("Got %v, expected A record of %s", updates[1], clientAddrPort.Addr())
+	}
+	if h := updates[2].Header; h.Type != dnsmessage.TypeSRV || h.Class != dnsmessage.ClassANY || h.Length != 0 {
+		t.Errorf("Got %v, expected deletion of the SRV RRset", h)
+	}
+	srv, ok := updates[3].Body.(*dnsmessage.SRVResource)
+	if !ok || srv.Port != clientAddrPort.Port() || srv.Priority != 10 || srv.Weight != 5 || srv.Target.String() != "home.example.com." {
+		t.Errorf("Got %v, expected SRV record pointing to home.example.com. port %d", updates[3], clientAddrPort.Port())
+	}
+
+	// An update signed with another key is refused.
+	u.tsig = &TSIGKey{
+		Name:   key.Name,
+		Secret: []byte("another secret"),
+	}
+	if err = u.Update(context.Background(), clientAddrPort); !errors.Is(err, ErrUpdateFailed) {
+		t.Errorf("Got error %v, expected %v", err, ErrUpdateFailed)
+	}
+}
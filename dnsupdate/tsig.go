@@ -0,0 +1,212 @@
+package dnsupdate
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"github.com/database64128/opdt-go/secret"
+)
+
+const (
+	// typeTSIG is the RR type of a transaction signature.
+	typeTSIG = 250
+
+	// classANY is the ANY RR class.
+	classANY = 255
+
+	// tsigFudge is the permitted clock skew, in seconds, between the signer and the verifier.
+	tsigFudge = 300
+)
+
+var (
+	ErrUnknownTSIGAlgorithm = errors.New("unknown TSIG algorithm")
+	ErrBadTSIG              = errors.New("bad TSIG signature")
+)
+
+// TSIGKey is a shared secret key for signing messages with TSIG (RFC 8945).
+type TSIGKey struct {
+	// Name is the name of the key, as configured on the DNS server.
+	Name string `json:"name"`
+
+	// Algorithm is the name of the HMAC algorithm.
+	// Supported algorithms are "hmac-sha1", "hmac-sha256" and "hmac-sha512".
+	// If empty, "hmac-sha256" is used.
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// Secret is the shared secret.
+	Secret secret.Key `json:"secret"`
+}
+
+// algorithmName returns the canonical algorithm name, as a fully qualified domain name.
+func (k *TSIGKey) algorithmName() string {
+	if k.Algorithm == "" {
+		return "hmac-sha256."
+	}
+	return canonicalName(k.Algorithm)
+}
+
+// newHash returns a new HMAC for the key's algorithm.
+func (k *TSIGKey) newHash() (hash.Hash, error) {
+	var h func() hash.Hash
+	switch k.algorithmName() {
+	case "hmac-sha1.":
+		h = sha1.New
+	case "hmac-sha256.":
+		h = sha256.New
+	case "hmac-sha512.":
+		h = sha512.New
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTSIGAlgorithm, k.Algorithm)
+	}
+	return hmac.New(h, k.Secret), nil
+}
+
+// noErrorNoOtherData is the Error, Other Len and Other Data fields of a TSIG record without error or other data.
+var noErrorNoOtherData = []byte{0, 0, 0, 0}
+
+// tsigVariables returns the TSIG variables that are digested after the message, in wire format.
+// tail holds the Error, Other Len and Other Data fields.
+func (k *TSIGKey) tsigVariables(timeSigned uint64, fudge uint16, tail []byte) []byte {
+	b := appendName(nil, canonicalName(k.Name))
+	b = binary.BigEndian.AppendUint16(b, classANY)
+	b = binary.BigEndian.AppendUint32(b, 0) // TTL
+	b = appendName(b, k.algorithmName())
+	b = appendUint48(b, timeSigned)
+	b = binary.BigEndian.AppendUint16(b, fudge)
+	return append(b, tail...)
+}
+
+// Sign appends a TSIG record to the message and returns the signed message and the MAC.
+func (k *TSIGKey) Sign(msg []byte, now time.Time) ([]byte, []byte, error) {
+	if len(msg) < headerLen {
+		return nil, nil, errMessageTooShort
+	}
+
+	h, err := k.newHash()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	timeSigned := uint64(now.Unix())
+	h.Write(msg)
+	h.Write(k.tsigVariables(timeSigned, tsigFudge, noErrorNoOtherData))
+	mac := h.Sum(nil)
+
+	// TSIG RR.
+	rdata := appendName(nil, k.algorithmName())
+	rdata = appendUint48(rdata, timeSigned)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(mac)))
+	rdata = append(rdata, mac...)
+	rdata = append(rdata, msg[0], msg[1]) // Original ID
+	rdata = append(rdata, noErrorNoOtherData...)
+
+	// Clip msg, so that appending never writes to the caller's buffer.
+	signed := appendName(msg[:len(msg):len(msg)], canonicalName(k.Name))
+	signed = binary.BigEndian.AppendUint16(signed, typeTSIG)
+	signed = binary.BigEndian.AppendUint16(signed, classANY)
+	signed = binary.BigEndian.AppendUint32(signed, 0)
+	signed = binary.BigEndian.AppendUint16(signed, uint16(len(rdata)))
+	signed = append(signed, rdata...)
+
+	// Increment ARCOUNT.
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(signed[10:])+1)
+
+	return signed, mac, nil
+}
+
+// VerifyResponse verifies the TSIG record of a response to a request signed with requestMAC.
+func (k *TSIGKey) VerifyResponse(resp, requestMAC []byte, now time.Time) error {
+	tsigOff, err := lastRecordOffset(resp)
+	if err != nil {
+		return err
+	}
+
+	name, off, err := readName(resp, tsigOff)
+	if err != nil {
+		return err
+	}
+	if len(resp) < off+10 {
+		return errMessageTooShort
+	}
+	if binary.BigEndian.Uint16(resp[off:]) != typeTSIG {
+		return fmt.Errorf("%w: response is not signed", ErrBadTSIG)
+	}
+	if !strings.EqualFold(name, canonicalName(k.Name)) {
+		return fmt.Errorf("%w: unexpected key name %q", ErrBadTSIG, name)
+	}
+	rdataLen := int(binary.BigEndian.Uint16(resp[off+8:]))
+	rdata := resp[off+10:]
+	if len(rdata) != rdataLen {
+		return errMessageTooShort
+	}
+
+	algorithm, rdataOff, err := readName(rdata, 0)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(algorithm, k.algorithmName()) {
+		return fmt.Errorf("%w: unexpected algorithm %q", ErrBadTSIG, algorithm)
+	}
+	if len(rdata) < rdataOff+10 {
+		return errMessageTooShort
+	}
+	timeSigned := readUint48(rdata[rdataOff:])
+	fudge := binary.BigEndian.Uint16(rdata[rdataOff+6:])
+	macLen := int(binary.BigEndian.Uint16(rdata[rdataOff+8:]))
+	rdataOff += 10
+	if len(rdata) < rdataOff+macLen+6 {
+		return errMessageTooShort
+	}
+	mac := rdata[rdataOff : rdataOff+macLen]
+	rdataOff += macLen
+	originalID := rdata[rdataOff : rdataOff+2]
+	tsigError := binary.BigEndian.Uint16(rdata[rdataOff+2:])
+	if tsigError != 0 {
+		return fmt.Errorf("%w: server returned TSIG error %d", ErrBadTSIG, tsigError)
+	}
+
+	h, err := k.newHash()
+	if err != nil {
+		return err
+	}
+
+	// The response is digested without its TSIG record, with the original ID and ARCOUNT.
+	unsigned := make([]byte, tsigOff)
+	copy(unsigned, resp)
+	copy(unsigned, originalID)
+	binary.BigEndian.PutUint16(unsigned[10:], binary.BigEndian.Uint16(unsigned[10:])-1)
+
+	h.Write(binary.BigEndian.AppendUint16(nil, uint16(len(requestMAC))))
+	h.Write(requestMAC)
+	h.Write(unsigned)
+	h.Write(k.tsigVariables(timeSigned, fudge, rdata[rdataOff+2:]))
+
+	if !hmac.Equal(h.Sum(nil), mac) {
+		return ErrBadTSIG
+	}
+
+	diff := now.Unix() - int64(timeSigned)
+	if diff < -int64(fudge) || diff > int64(fudge) {
+		return fmt.Errorf("%w: time signed %d is outside the fudge window", ErrBadTSIG, timeSigned)
+	}
+
+	return nil
+}
+
+func appendUint48(b []byte, v uint64) []byte {
+	return append(b, byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func readUint48(b []byte) uint64 {
+	_ = b[5]
+	return uint64(b[0])<<40 | uint64(b[1])<<32 | uint64(b[2])<<24 | uint64(b[3])<<16 | uint64(b[4])<<8 | uint64(b[5])
+}
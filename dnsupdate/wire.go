@@ -0,0 +1,141 @@
+package dnsupdate
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// headerLen is the length of a DNS message header.
+const headerLen = 12
+
+var (
+	errMessageTooShort = errors.New("DNS message too short")
+	errBadName         = errors.New("bad domain name in DNS message")
+)
+
+// canonicalName returns the name in lower case, with a trailing dot.
+func canonicalName(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// appendName appends the fully qualified domain name in uncompressed wire format.
+// The name must be a valid domain name.
+func appendName(b []byte, name string) []byte {
+	for label := range strings.SplitSeq(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// readName reads a possibly compressed domain name at off in msg,
+// and returns the name and the offset after it.
+func readName(msg []byte, off int) (string, int, error) {
+	var (
+		sb       strings.Builder
+		end      = -1
+		pointers int
+	)
+
+	for {
+		if off >= len(msg) {
+			return "", 0, errMessageTooShort
+		}
+		c := int(msg[off])
+		switch c & 0xC0 {
+		case 0x00:
+			if c == 0 {
+				if end == -1 {
+					end = off + 1
+				}
+				if sb.Len() == 0 {
+					sb.WriteByte('.')
+				}
+				return sb.String(), end, nil
+			}
+			if off+1+c > len(msg) {
+				return "", 0, errMessageTooShort
+			}
+			sb.Write(msg[off+1 : off+1+c])
+			sb.WriteByte('.')
+			off += 1 + c
+		case 0xC0:
+			if off+2 > len(msg) {
+				return "", 0, errMessageTooShort
+			}
+			if end == -1 {
+				end = off + 2
+			}
+			// Guard against pointer loops.
+			if pointers++; pointers > 64 {
+				return "", 0, errBadName
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		default:
+			return "", 0, errBadName
+		}
+	}
+}
+
+// skipName returns the offset after the domain name at off in msg.
+func skipName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errMessageTooShort
+		}
+		c := int(msg[off])
+		switch c & 0xC0 {
+		case 0x00:
+			off += 1 + c
+			if c == 0 {
+				return off, nil
+			}
+		case 0xC0:
+			return off + 2, nil
+		default:
+			return 0, errBadName
+		}
+	}
+}
+
+// lastRecordOffset returns the offset of the last resource record in msg.
+func lastRecordOffset(msg []byte) (int, error) {
+	if len(msg) < headerLen {
+		return 0, errMessageTooShort
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	rrcount := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	if rrcount == 0 {
+		return 0, errMessageTooShort
+	}
+
+	off := headerLen
+	var err error
+	for range qdcount {
+		if off, err = skipName(msg, off); err != nil {
+			return 0, err
+		}
+		off += 4
+	}
+	for range rrcount - 1 {
+		if off, err = skipName(msg, off); err != nil {
+			return 0, err
+		}
+		if off+10 > len(msg) {
+			return 0, errMessageTooShort
+		}
+		off += 10 + int(binary.BigEndian.Uint16(msg[off+8:]))
+	}
+	if off >= len(msg) {
+		return 0, errMessageTooShort
+	}
+	return off, nil
+}
@@ -5,6 +5,7 @@ go 1.26.0
 require (
 	go.uber.org/zap v1.28.0
 	golang.org/x/crypto v0.53.0
+	golang.org/x/net v0.55.0
 	golang.org/x/sys v0.46.0
 )
 
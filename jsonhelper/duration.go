@@ -0,0 +1,22 @@
+package jsonhelper
+
+import "time"
+
+// Duration is a [time.Duration] that is marshaled as a string
+// in the format accepted by [time.ParseDuration], such as "1h30m".
+type Duration time.Duration
+
+// MarshalText implements [encoding.TextMarshaler].
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler].
+func (d *Duration) UnmarshalText(text []byte) error {
+	dd, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(dd)
+	return nil
+}
@@ -3,6 +3,7 @@ package jsonhelper
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
 )
 
 // OpenAndDecodeDisallowUnknownFields opens the file at path and decodes it into v, disallowing unknown fields.
@@ -17,3 +18,49 @@ func OpenAndDecodeDisallowUnknownFields(path string, v any) error {
 	d.DisallowUnknownFields()
 	return d.Decode(v)
 }
+
+// EncodeAndWriteFileAtomic encodes v as indented JSON and writes it to the file at path.
+//
+// The data is written to a temporary file in the same directory, synced, and renamed over path,
+// so that readers never observe a partially written file.
+func EncodeAndWriteFileAtomic(path string, v any, perm os.FileMode) error {
+	b, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	if err = writeSyncClose(f, b, perm); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// writeSyncClose sets the file mode, writes b, syncs, and closes the file.
+func writeSyncClose(f *os.File, b []byte, perm os.FileMode) error {
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
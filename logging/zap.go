@@ -11,6 +11,7 @@ import (
 )
 
 // NewZapLogger returns a new [*zap.Logger] with the given preset and log level.
+// The log level can be changed at runtime through the given [zap.AtomicLevel].
 //
 // The available presets are:
 //
@@ -24,8 +25,9 @@ import (
 //
 // If the preset is not recognized, it is treated as a path to a JSON configuration file.
 //
-// The log level does not apply to the "production", "development", or custom presets.
-func NewZapLogger(preset string, level zapcore.Level) (*zap.Logger, error) {
+// The initial log level does not apply to the "production", "development", or custom presets.
+// For these presets, level is set to the level in the preset's configuration.
+func NewZapLogger(preset string, level zap.AtomicLevel) (*zap.Logger, error) {
 	switch preset {
 	case "console":
 		return NewProductionConsoleZapLogger(level, false, false, false), nil
@@ -48,13 +50,17 @@ func NewZapLogger(preset string, level zapcore.Level) (*zap.Logger, error) {
 			return nil, fmt.Errorf("failed to load zap logger config from file %q: %w", preset, err)
 		}
 	}
+	if cfg.Level != (zap.AtomicLevel{}) {
+		level.SetLevel(cfg.Level.Level())
+	}
+	cfg.Level = level
 	return cfg.Build()
 }
 
 // NewProductionConsoleZapLogger creates a new [*zap.Logger] with reasonable defaults for production console environments.
 //
 // See [NewProductionConsoleEncoderConfig] for information on the default encoder configuration.
-func NewProductionConsoleZapLogger(level zapcore.Level, noColor, noTime, addCaller bool) *zap.Logger {
+func NewProductionConsoleZapLogger(level zapcore.LevelEnabler, noColor, noTime, addCaller bool) *zap.Logger {
 	cfg := NewProductionConsoleEncoderConfig(noColor, noTime)
 	enc := zapcore.NewConsoleEncoder(cfg)
 	core := zapcore.NewCore(enc, zapcore.Lock(os.Stderr), level)
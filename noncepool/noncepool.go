@@ -1,15 +1,21 @@
 package noncepool
 
-import "time"
+import (
+	"sync/atomic"
+	"time"
+)
 
 // NoncePool stores nonces for [retention, 2*retention) to protect against replay attacks
 // during the replay window.
 //
-// NoncePool is not safe for concurrent use.
+// NoncePool is not safe for concurrent use, except for [NoncePool.Len].
 type NoncePool[T comparable] struct {
 	pool      map[T]time.Time
 	retention time.Duration
 	lastClean time.Time
+
+	// size is the number of nonces in pool, for readers on other goroutines.
+	size atomic.Int64
 }
 
 // clean removes expired nonces from the pool.
@@ -21,6 +27,7 @@ func (p *NoncePool[T]) clean() {
 			}
 		}
 		p.lastClean = now
+		p.size.Store(int64(len(p.pool)))
 	}
 }
 
@@ -34,6 +41,13 @@ func (p *NoncePool[T]) Check(nonce T) bool {
 // Add adds the given nonce to the pool.
 func (p *NoncePool[T]) Add(nonce T) {
 	p.pool[nonce] = time.Now()
+	p.size.Store(int64(len(p.pool)))
+}
+
+// Len returns the number of nonces in the pool.
+// It may be called concurrently with the other methods.
+func (p *NoncePool[T]) Len() int {
+	return int(p.size.Load())
 }
 
 // New returns a new NoncePool with the given retention.
@@ -40,6 +40,23 @@ func (c *Client) PutRequest(req []byte) {
 	c.aead.Seal(nonce, nonce, plaintext, nil)
 }
 
+// PutRequestWithLocalAddress writes a request packet carrying the client's local address
+// to the first [RequestWithLocalAddressPacketSize] bytes of the given buffer.
+// The local address is encrypted along with the rest of the request.
+func (c *Client) PutRequestWithLocalAddress(req []byte, localAddrPort netip.AddrPort) {
+	_ = req[RequestWithLocalAddressPacketSize-1]
+
+	nonce := req[:chacha20poly1305.NonceSizeX]
+	rand.Read(nonce)
+
+	plaintext := req[chacha20poly1305.NonceSizeX : RequestWithLocalAddressPacketSize-chacha20poly1305.Overhead]
+	binary.BigEndian.PutUint64(plaintext, uint64(time.Now().Unix()))
+	plaintext[8] = MessageTypeRequestWithLocalAddress
+	*(*[16]byte)(plaintext[9:]) = localAddrPort.Addr().As16()
+	binary.BigEndian.PutUint16(plaintext[25:], localAddrPort.Port())
+	c.aead.Seal(nonce, nonce, plaintext, nil)
+}
+
 // ParseResponse parses the response packet and returns the client IP and port.
 func (c *Client) ParseResponse(resp []byte) (netip.AddrPort, error) {
 	if len(resp) != ResponsePacketSize {
@@ -50,7 +67,7 @@ func (c *Client) ParseResponse(resp []byte) (netip.AddrPort, error) {
 	ciphertext := resp[chacha20poly1305.NonceSizeX:]
 	plaintext, err := c.aead.Open(ciphertext[:0], nonce, ciphertext, nil)
 	if err != nil {
-		return netip.AddrPort{}, err
+		return netip.AddrPort{}, ErrAuthenticationFailed
 	}
 
 	if err = CheckUnixEpochTimestamp(plaintext); err != nil {
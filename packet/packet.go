@@ -12,12 +12,19 @@ import (
 const (
 	MessageTypeRequest = iota
 	MessageTypeResponse
+	MessageTypeRequestWithLocalAddress
 )
 
 const (
 	// random nonce + unix epoch timestamp + type + AEAD tag
 	RequestPacketSize = chacha20poly1305.NonceSizeX + 8 + 1 + chacha20poly1305.Overhead
 
+	// random nonce + unix epoch timestamp + type + local IP + local port + AEAD tag
+	RequestWithLocalAddressPacketSize = chacha20poly1305.NonceSizeX + 8 + 1 + 16 + 2 + chacha20poly1305.Overhead
+
+	// MaxRequestPacketSize is the size of the largest request packet.
+	MaxRequestPacketSize = RequestWithLocalAddressPacketSize
+
 	// random nonce + unix epoch timestamp + type + IP + port + AEAD tag
 	ResponsePacketSize = chacha20poly1305.NonceSizeX + 8 + 1 + 16 + 2 + chacha20poly1305.Overhead
 )
@@ -31,13 +38,21 @@ const (
 
 	// ReplayWindowDuration defines the amount of time during which a nonce check is necessary.
 	ReplayWindowDuration = MaxTimeDiff * 2
+
+	// MaxKeys is the maximum number of PSKs of a server.
+	// Requests carry no key identifier, so a request that matches no key,
+	// such as any packet of an unauthenticated flood, costs one decryption attempt per key.
+	MaxKeys = 16
 )
 
 var (
-	ErrBadPacketSize  = errors.New("bad packet size")
-	ErrRepeatedNonce  = errors.New("repeated nonce")
-	ErrBadTimestamp   = errors.New("time offset too large")
-	ErrBadMessageType = errors.New("bad message type")
+	ErrBadPacketSize        = errors.New("bad packet size")
+	ErrRepeatedNonce        = errors.New("repeated nonce")
+	ErrBadTimestamp         = errors.New("time offset too large")
+	ErrBadMessageType       = errors.New("bad message type")
+	ErrAuthenticationFailed = errors.New("message authentication failed")
+	ErrNoKeys               = errors.New("no keys")
+	ErrTooManyKeys          = errors.New("too many keys")
 )
 
 // CheckUnixEpochTimestamp checks the Unix Epoch timestamp in the buffer
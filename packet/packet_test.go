@@ -2,6 +2,7 @@ package packet
 
 import (
 	"crypto/rand"
+	"errors"
 	"net/netip"
 	"testing"
 
@@ -24,7 +25,7 @@ func TestClientServer(t *testing.T) {
 	clientAddrPort := netip.AddrPortFrom(netip.IPv6Unspecified(), 60000)
 
 	client.PutRequest(req)
-	if err = server.Handle(clientAddrPort, req, resp); err != nil {
+	if _, _, err = server.Handle(clientAddrPort, req, resp); err != nil {
 		t.Fatal(err)
 	}
 	addrPort, err := client.ParseResponse(resp)
@@ -35,3 +36,109 @@ func TestClientServer(t *testing.T) {
 		t.Errorf("Got client address %s, expected %s", addrPort, clientAddrPort)
 	}
 }
+
+func TestServerMultipleKeys(t *testing.T) {
+	psks := make([][]byte, 3)
+	for i := range psks {
+		psks[i] = make([]byte, chacha20poly1305.KeySize)
+		rand.Read(psks[i])
+	}
+	server, err := NewServer(psks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := make([]byte, RequestPacketSize)
+	resp := make([]byte, ResponsePacketSize)
+	clientAddrPort := netip.AddrPortFrom(netip.IPv4Unspecified(), 60000)
+
+	for i, psk := range psks {
+		client, err := NewClient(psk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client.PutRequest(req)
+		keyIndex, _, err := server.Handle(clientAddrPort, req, resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keyIndex != i {
+			t.Errorf("Got key index %d, expected %d", keyIndex, i)
+		}
+
+		addrPort, err := client.ParseResponse(resp)
+		if err != nil {
+			t.Error(err)
+		}
+		if addrPort != clientAddrPort {
+			t.Errorf("Got client address %s, expected %s", addrPort, clientAddrPort)
+		}
+
+		if _, _, err = server.Handle(clientAddrPort, req, resp); err != ErrRepeatedNonce {
+			t.Errorf("Got error %v, expected %v", err, ErrRepeatedNonce)
+		}
+	}
+
+	unknownPSK := make([]byte, chacha20poly1305.KeySize)
+	rand.Read(unknownPSK)
+	client, err := NewClient(unknownPSK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.PutRequest(req)
+	if _, _, err = server.Handle(clientAddrPort, req, resp); err != ErrAuthenticationFailed {
+		t.Errorf("Got error %v, expected %v", err, ErrAuthenticationFailed)
+	}
+}
+
+func TestServerMaxKeys(t *testing.T) {
+	psks := make([][]byte, MaxKeys+1)
+	for i := range psks {
+		psks[i] = make([]byte, chacha20poly1305.KeySize)
+	}
+	if _, err := NewServer(psks[:MaxKeys]...); err != nil {
+		t.Errorf("NewServer with %d keys failed: %v", MaxKeys, err)
+	}
+	if _, err := NewServer(psks...); !errors.Is(err, ErrTooManyKeys) {
+		t.Errorf("Got error %v, expected %v", err, ErrTooManyKeys)
+	}
+}
+
+func TestClientServerWithLocalAddress(t *testing.T) {
+	psk := make([]byte, chacha20poly1305.KeySize)
+	rand.Read(psk)
+	client, err := NewClient(psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := make([]byte, RequestWithLocalAddressPacketSize)
+	resp := make([]byte, ResponsePacketSize)
+	clientAddrPort := netip.MustParseAddrPort("[2001:db8::1]:60000")
+	localAddrPort := netip.MustParseAddrPort("192.168.1.2:10128")
+
+	client.PutRequestWithLocalAddress(req, localAddrPort)
+	_, gotLocalAddrPort, err := server.Handle(clientAddrPort, req, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotLocalAddrPort != localAddrPort {
+		t.Errorf("Got local address %s, expected %s", gotLocalAddrPort, localAddrPort)
+	}
+	addrPort, err := client.ParseResponse(resp)
+	if err != nil {
+		t.Error(err)
+	}
+	if addrPort != clientAddrPort {
+		t.Errorf("Got client address %s, expected %s", addrPort, clientAddrPort)
+	}
+
+	// A request without a local address must not be accepted at the extended size.
+	client.PutRequest(req)
+	if _, _, err = server.Handle(clientAddrPort, req, resp); err != ErrAuthenticationFailed {
+		t.Errorf("Got error %v, expected %v", err, ErrAuthenticationFailed)
+	}
+}
@@ -14,51 +14,120 @@ import (
 
 // Server generates responses to request packets.
 type Server struct {
-	aead      cipher.AEAD
+	aeads     []cipher.AEAD
 	noncePool *noncepool.NoncePool[[chacha20poly1305.NonceSizeX]byte]
 }
 
-// NewServer creates a new server with the given PSK.
-func NewServer(psk []byte) (*Server, error) {
-	aead, err := chacha20poly1305.NewX(psk)
+// NewServer creates a new server with the given PSKs, at most [MaxKeys].
+// A request is accepted if it is encrypted with any of the PSKs.
+func NewServer(psks ...[]byte) (*Server, error) {
+	aeads, err := newAEADs(psks)
 	if err != nil {
 		return nil, err
 	}
 	return &Server{
-		aead:      aead,
+		aeads:     aeads,
 		noncePool: noncepool.New[[chacha20poly1305.NonceSizeX]byte](ReplayWindowDuration),
 	}, nil
 }
 
+// newAEADs creates an AEAD for each PSK.
+func newAEADs(psks [][]byte) ([]cipher.AEAD, error) {
+	if len(psks) == 0 {
+		return nil, ErrNoKeys
+	}
+	if len(psks) > MaxKeys {
+		return nil, fmt.Errorf("%w: %d, at most %d", ErrTooManyKeys, len(psks), MaxKeys)
+	}
+	aeads := make([]cipher.AEAD, len(psks))
+	for i, psk := range psks {
+		aead, err := chacha20poly1305.NewX(psk)
+		if err != nil {
+			return nil, err
+		}
+		aeads[i] = aead
+	}
+	return aeads, nil
+}
+
+// WithKeys returns a new server that accepts requests encrypted with the given PSKs,
+// and shares the nonce pool of s, so that replay protection carries over.
+//
+// The returned server must not be used concurrently with s.
+func (s *Server) WithKeys(psks ...[]byte) (*Server, error) {
+	aeads, err := newAEADs(psks)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		aeads:     aeads,
+		noncePool: s.noncePool,
+	}, nil
+}
+
+// ReplayCacheSize returns the number of nonces in the replay protection cache.
+// It may be called concurrently with [Server.Handle].
+func (s *Server) ReplayCacheSize() int {
+	return s.noncePool.Len()
+}
+
 // Handle processes the request packet and writes the response packet to the first [ResponsePacketSize] bytes of the given buffer.
-func (s *Server) Handle(clientAddrPort netip.AddrPort, req []byte, resp []byte) error {
+// It returns the index of the PSK that authenticated the request,
+// and the client's local address if the request carries one.
+//
+// The request is decrypted with each PSK in turn, so the cost of a request
+// that fails authentication grows with the number of PSKs.
+func (s *Server) Handle(clientAddrPort netip.AddrPort, req []byte, resp []byte) (keyIndex int, localAddrPort netip.AddrPort, err error) {
 	_ = resp[ResponsePacketSize-1]
 
 	// Process request.
-	if len(req) != RequestPacketSize {
-		return ErrBadPacketSize
+	var expectedMessageType byte
+	switch len(req) {
+	case RequestPacketSize:
+		expectedMessageType = MessageTypeRequest
+	case RequestWithLocalAddressPacketSize:
+		expectedMessageType = MessageTypeRequestWithLocalAddress
+	default:
+		return 0, netip.AddrPort{}, ErrBadPacketSize
 	}
 
 	nonce := req[:chacha20poly1305.NonceSizeX]
 	reqNonce := *(*[chacha20poly1305.NonceSizeX]byte)(nonce)
 	if !s.noncePool.Check(reqNonce) {
-		return ErrRepeatedNonce
+		return 0, netip.AddrPort{}, ErrRepeatedNonce
 	}
 
+	// Try each key in turn. Decrypt into a separate buffer,
+	// because a failed Open may clobber the destination.
+	var (
+		plaintextBuf [RequestWithLocalAddressPacketSize - chacha20poly1305.NonceSizeX - chacha20poly1305.Overhead]byte
+		plaintext    []byte
+	)
 	ciphertext := req[chacha20poly1305.NonceSizeX:]
-	plaintext, err := s.aead.Open(ciphertext[:0], nonce, ciphertext, nil)
+	for keyIndex = range s.aeads {
+		plaintext, err = s.aeads[keyIndex].Open(plaintextBuf[:0], nonce, ciphertext, nil)
+		if err == nil {
+			break
+		}
+	}
 	if err != nil {
-		return err
+		return 0, netip.AddrPort{}, ErrAuthenticationFailed
 	}
 
 	if err = CheckUnixEpochTimestamp(plaintext); err != nil {
-		return err
+		return 0, netip.AddrPort{}, err
 	}
 
 	s.noncePool.Add(reqNonce)
 
-	if plaintext[8] != MessageTypeRequest {
-		return fmt.Errorf("%w: %d, expected %d", ErrBadMessageType, plaintext[8], MessageTypeRequest)
+	if plaintext[8] != expectedMessageType {
+		return 0, netip.AddrPort{}, fmt.Errorf("%w: %d, expected %d", ErrBadMessageType, plaintext[8], expectedMessageType)
+	}
+
+	if expectedMessageType == MessageTypeRequestWithLocalAddress {
+		addr := netip.AddrFrom16(*(*[16]byte)(plaintext[9:])).Unmap()
+		port := binary.BigEndian.Uint16(plaintext[25:])
+		localAddrPort = netip.AddrPortFrom(addr, port)
 	}
 
 	// Generate response.
@@ -70,6 +139,6 @@ func (s *Server) Handle(clientAddrPort netip.AddrPort, req []byte, resp []byte)
 	plaintext[8] = MessageTypeResponse
 	*(*[16]byte)(plaintext[9:]) = clientAddrPort.Addr().As16()
 	binary.BigEndian.PutUint16(plaintext[25:], clientAddrPort.Port())
-	s.aead.Seal(nonce, nonce, plaintext, nil)
-	return nil
+	s.aeads[keyIndex].Seal(nonce, nonce, plaintext, nil)
+	return keyIndex, localAddrPort, nil
 }
@@ -0,0 +1,157 @@
+package secret
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// DefaultArgon2idTime is the default number of passes over the memory.
+	DefaultArgon2idTime = 3
+
+	// DefaultArgon2idMemory is the default memory size in KiB.
+	DefaultArgon2idMemory = 64 * 1024
+
+	// DefaultArgon2idThreads is the default degree of parallelism.
+	DefaultArgon2idThreads = 4
+
+	// minArgon2idSaltSize is the minimum salt size allowed by the Argon2 specification.
+	minArgon2idSaltSize = 8
+
+	// derivedKeySize is the size of derived keys, the key size of XChaCha20-Poly1305.
+	derivedKeySize = 32
+
+	// recommendedArgon2idSaltSize is the salt size recommended by RFC 9106.
+	recommendedArgon2idSaltSize = 16
+
+	// minRecommendedArgon2idCost is the minimum recommended product of memory in KiB and passes,
+	// that of the OWASP recommendation of 19 MiB and 2 passes.
+	minRecommendedArgon2idCost = 19 * 1024 * 2
+)
+
+var (
+	ErrNoPassphrase       = errors.New("no passphrase")
+	ErrPassphraseAndFile  = errors.New("passphrase and passphrase file are mutually exclusive")
+	ErrArgon2idSaltLength = fmt.Errorf("salt must be at least %d bytes", minArgon2idSaltSize)
+)
+
+// Redacted replaces a non-empty [Passphrase] wherever it is formatted or marshaled.
+const Redacted = "[REDACTED]"
+
+// Passphrase is a secret passphrase.
+//
+// It formats and marshals as [Redacted]. Unlike [Key], it is not shown as a fingerprint,
+// as an unsalted hash of a low-entropy passphrase can be brute-forced offline.
+// Use the fingerprint of the derived key instead.
+type Passphrase string
+
+// String implements [fmt.Stringer] by returning [Redacted], or "" if the passphrase is empty.
+func (p Passphrase) String() string {
+	if p == "" {
+		return ""
+	}
+	return Redacted
+}
+
+// GoString implements [fmt.GoStringer] by returning [Redacted].
+func (p Passphrase) GoString() string {
+	return p.String()
+}
+
+// Format implements [fmt.Formatter] by writing [Redacted] for all verbs.
+func (p Passphrase) Format(f fmt.State, verb rune) {
+	_, _ = f.Write([]byte(p.String()))
+}
+
+// MarshalText implements [encoding.TextMarshaler] by returning [Redacted].
+func (p Passphrase) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler].
+func (p *Passphrase) UnmarshalText(text []byte) error {
+	*p = Passphrase(text)
+	return nil
+}
+
+// Argon2idConfig derives a key from a passphrase with Argon2id.
+//
+// The same passphrase, salt and parameters always derive the same key,
+// so they must match between clients and servers.
+type Argon2idConfig struct {
+	// Passphrase is the passphrase to derive the key from.
+	Passphrase Passphrase `json:"passphrase,omitempty"`
+
+	// PassphraseFile is the path to a file containing the passphrase, used instead of Passphrase.
+	// The file is read as [ReadFile] does.
+	PassphraseFile string `json:"passphraseFile,omitempty"`
+
+	// Salt is the salt, at least 8 bytes. 16 random bytes are recommended.
+	Salt []byte `json:"salt"`
+
+	// Time is the number of passes over the memory.
+	// If zero, [DefaultArgon2idTime] is used.
+	Time uint32 `json:"time,omitempty"`
+
+	// Memory is the memory size in KiB.
+	// If zero, [DefaultArgon2idMemory] is used.
+	Memory uint32 `json:"memory,omitempty"`
+
+	// Threads is the degree of parallelism.
+	// If zero, [DefaultArgon2idThreads] is used.
+	Threads uint8 `json:"threads,omitempty"`
+}
+
+// params returns the parameters, with defaults applied.
+func (c *Argon2idConfig) params() (time, memory uint32, threads uint8) {
+	time, memory, threads = c.Time, c.Memory, c.Threads
+	if time == 0 {
+		time = DefaultArgon2idTime
+	}
+	if memory == 0 {
+		memory = DefaultArgon2idMemory
+	}
+	if threads == 0 {
+		threads = DefaultArgon2idThreads
+	}
+	return time, memory, threads
+}
+
+// Warnings returns descriptions of the settings that are weaker than recommended.
+func (c *Argon2idConfig) Warnings() []string {
+	var warnings []string
+	if len(c.Salt) < recommendedArgon2idSaltSize {
+		warnings = append(warnings, fmt.Sprintf("salt is %d bytes, %d are recommended", len(c.Salt), recommendedArgon2idSaltSize))
+	}
+	if time, memory, _ := c.params(); uint64(time)*uint64(memory) < minRecommendedArgon2idCost {
+		warnings = append(warnings, fmt.Sprintf("%d passes over %d KiB is weaker than the recommended 2 passes over 19 MiB", time, memory))
+	}
+	return warnings
+}
+
+// Key derives the 32-byte key.
+func (c *Argon2idConfig) Key() (Key, error) {
+	passphrase := []byte(c.Passphrase)
+	if c.PassphraseFile != "" {
+		if len(passphrase) > 0 {
+			return nil, ErrPassphraseAndFile
+		}
+		b, err := ReadFile(c.PassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		passphrase = b
+	}
+	if len(passphrase) == 0 {
+		return nil, ErrNoPassphrase
+	}
+
+	if len(c.Salt) < minArgon2idSaltSize {
+		return nil, ErrArgon2idSaltLength
+	}
+
+	time, memory, threads := c.params()
+	return argon2.IDKey(passphrase, c.Salt, time, memory, threads, derivedKeySize), nil
+}
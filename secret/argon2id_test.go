@@ -0,0 +1,92 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArgon2idConfigKey(t *testing.T) {
+	c := Argon2idConfig{
+		Passphrase: "correct horse battery staple",
+		Salt:       []byte("0123456789abcdef"),
+		Time:       1,
+		Memory:     1024,
+		Threads:    1,
+	}
+
+	key, err := c.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != derivedKeySize {
+		t.Fatalf("Got key length %d, expected %d", len(key), derivedKeySize)
+	}
+
+	path := filepath.Join(t.TempDir(), "passphrase")
+	if err = os.WriteFile(path, []byte("correct horse battery staple\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fc := c
+	fc.Passphrase = ""
+	fc.PassphraseFile = path
+	fileKey, err := LoadKey(nil, "", &fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fileKey, key) {
+		t.Errorf("Got key %s from passphrase file, expected %s", fileKey, key)
+	}
+
+	sc := c
+	sc.Salt = []byte("fedcba9876543210")
+	saltKey, err := sc.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(saltKey, key) {
+		t.Error("Expected a different salt to derive a different key")
+	}
+
+	sc.Salt = []byte("short")
+	if _, err = sc.Key(); !errors.Is(err, ErrArgon2idSaltLength) {
+		t.Errorf("Got error %v, expected %v", err, ErrArgon2idSaltLength)
+	}
+
+	if _, err = (&Argon2idConfig{Salt: c.Salt}).Key(); !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("Got error %v, expected %v", err, ErrNoPassphrase)
+	}
+}
+
+func TestPassphraseRedacted(t *testing.T) {
+	const passphrase = "correct horse battery staple"
+	c := Argon2idConfig{Passphrase: passphrase, Salt: []byte("0123456789abcdef")}
+
+	b, err := json.Marshal(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		fmt.Sprint(c.Passphrase),
+		fmt.Sprintf("%#v", c),
+		fmt.Sprintf("%x", c.Passphrase),
+		string(b),
+	} {
+		if !strings.Contains(s, Redacted) {
+			t.Errorf("Got %q, expected it to contain %q", s, Redacted)
+		}
+		if strings.Contains(s, passphrase) || strings.Contains(s, Key(passphrase).Fingerprint()) {
+			t.Errorf("Got %q, expected the passphrase and its hash to be redacted", s)
+		}
+	}
+
+	if s := Passphrase("").String(); s != "" {
+		t.Errorf("Got %q for an empty passphrase, expected empty", s)
+	}
+}
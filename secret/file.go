@@ -0,0 +1,117 @@
+// Package secret loads secrets, such as pre-shared keys, from files and environment variables,
+// so that they can be kept out of world-readable configuration files and command lines.
+package secret
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CredentialsDirectoryEnv is the environment variable set by systemd to the directory of the service's credentials.
+const CredentialsDirectoryEnv = "CREDENTIALS_DIRECTORY"
+
+var (
+	ErrUnsafePermissions = errors.New("secret file is writable by group or accessible by others")
+	ErrConflictingKeys   = errors.New("a key, a key file and a derived key are mutually exclusive")
+)
+
+// Path returns the path of the secret file name.
+//
+// If name is a bare file name, and $CREDENTIALS_DIRECTORY is set, as for systemd services
+// with LoadCredential= or SetCredential=, name is looked up in that directory.
+// Otherwise, name is returned as is.
+func Path(name string) string {
+	if dir := os.Getenv(CredentialsDirectoryEnv); dir != "" && !strings.ContainsRune(name, os.PathSeparator) {
+		return filepath.Join(dir, name)
+	}
+	return name
+}
+
+// ReadFile reads the secret file name, as resolved by [Path],
+// with leading and trailing whitespace removed.
+//
+// On Unix, it returns an error wrapping [ErrUnsafePermissions] if the file is writable by its group,
+// or readable, writable or executable by others.
+func ReadFile(name string) ([]byte, error) {
+	path := Path(name)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err = checkPermissions(fi); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(b), nil
+}
+
+// ReadKeyFile reads the base64-encoded key from the secret file name, as [ReadFile] does.
+func ReadKeyFile(name string) (Key, error) {
+	b, err := ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var key Key
+	if err = key.UnmarshalText(b); err != nil {
+		return nil, fmt.Errorf("bad key in %s: %w", Path(name), err)
+	}
+	return key, nil
+}
+
+// LoadKey returns the configured key: key itself, the key read from the secret file,
+// or the key derived with the Argon2id configuration.
+// It returns nil if none is configured, and [ErrConflictingKeys] if more than one is.
+func LoadKey(key Key, file string, argon2id *Argon2idConfig) (Key, error) {
+	var n int
+	if len(key) > 0 {
+		n++
+	}
+	if file != "" {
+		n++
+	}
+	if argon2id != nil {
+		n++
+	}
+	if n > 1 {
+		return nil, ErrConflictingKeys
+	}
+
+	switch {
+	case file != "":
+		return ReadKeyFile(file)
+	case argon2id != nil:
+		return argon2id.Key()
+	default:
+		return key, nil
+	}
+}
+
+// KeyFromEnv returns the base64-encoded key in the environment variable name,
+// or nil if the variable is not set or empty.
+func KeyFromEnv(name string) (Key, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return nil, nil
+	}
+	var key Key
+	if err := key.Set(strings.TrimSpace(s)); err != nil {
+		return nil, fmt.Errorf("bad key in $%s: %w", name, err)
+	}
+	return key, nil
+}
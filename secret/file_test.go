@@ -0,0 +1,67 @@
+//go:build unix
+
+package secret
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	key := []byte("0123456789abcdef0123456789abcdef")
+	content := []byte("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=\n")
+
+	for _, c := range []struct {
+		name    string
+		perm    os.FileMode
+		wantErr error
+	}{
+		{"owner", 0o600, nil},
+		{"group-readable", 0o640, nil},
+		{"group-writable", 0o660, ErrUnsafePermissions},
+		{"world-readable", 0o644, ErrUnsafePermissions},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(dir, c.name)
+			if err := os.WriteFile(path, content, c.perm); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(path, c.perm); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ReadKeyFile(path)
+			if !errors.Is(err, c.wantErr) {
+				t.Fatalf("Got error %v, expected %v", err, c.wantErr)
+			}
+			if err == nil && !bytes.Equal(got, key) {
+				t.Errorf("Got key %q, expected %q", got, key)
+			}
+		})
+	}
+}
+
+func TestReadKeyFileCredentialsDirectory(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(CredentialsDirectoryEnv, dir)
+
+	if err := os.WriteFile(filepath.Join(dir, "opdt-psk"), []byte("AAECAw=="), 0o400); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadKeyFile("opdt-psk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0, 1, 2, 3}; !bytes.Equal(got, want) {
+		t.Errorf("Got key %v, expected %v", got, want)
+	}
+
+	if _, err = LoadKey(Key{0}, "opdt-psk", nil); !errors.Is(err, ErrConflictingKeys) {
+		t.Errorf("Got error %v, expected %v", err, ErrConflictingKeys)
+	}
+}
@@ -0,0 +1,64 @@
+package secret
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// fingerprintSize is the number of bytes of the SHA-256 hash of a key in its fingerprint.
+const fingerprintSize = 8
+
+// Key is a secret key.
+//
+// It decodes from base64 text, as []byte does, so it can be used in configuration files and flags.
+// It never prints or marshals its value: it formats and marshals as its fingerprint instead,
+// so that it does not leak into logs, errors and usage output.
+type Key []byte
+
+// Fingerprint returns a short hash of the key in the form "sha256:<hex>",
+// which identifies the key without revealing it.
+// It returns the empty string if the key is empty.
+func (k Key) Fingerprint() string {
+	if len(k) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(k)
+	return "sha256:" + hex.EncodeToString(sum[:fingerprintSize])
+}
+
+// String implements [fmt.Stringer] by returning the fingerprint.
+func (k Key) String() string {
+	return k.Fingerprint()
+}
+
+// GoString implements [fmt.GoStringer] by returning the fingerprint.
+func (k Key) GoString() string {
+	return k.Fingerprint()
+}
+
+// Format implements [fmt.Formatter] by writing the fingerprint for all verbs.
+func (k Key) Format(f fmt.State, verb rune) {
+	_, _ = f.Write([]byte(k.Fingerprint()))
+}
+
+// MarshalText implements [encoding.TextMarshaler] by returning the fingerprint.
+func (k Key) MarshalText() ([]byte, error) {
+	return []byte(k.Fingerprint()), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler] by decoding base64 text.
+func (k *Key) UnmarshalText(text []byte) error {
+	b, err := base64.StdEncoding.AppendDecode(nil, text)
+	if err != nil {
+		return err
+	}
+	*k = b
+	return nil
+}
+
+// Set implements [flag.Value] by decoding base64 text.
+func (k *Key) Set(s string) error {
+	return k.UnmarshalText([]byte(s))
+}
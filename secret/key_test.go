@@ -0,0 +1,55 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestKeyRedaction(t *testing.T) {
+	const encoded = "XbQZKDJTbbhuSwF0muQx6L9swsAmf0VOYIApri7nHUQ="
+
+	var v struct {
+		PSK Key `json:"psk"`
+	}
+	if err := json.Unmarshal([]byte(`{"psk":"`+encoded+`"}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.PSK) != 32 {
+		t.Fatalf("Got key length %d, expected 32", len(v.PSK))
+	}
+
+	fingerprint := v.PSK.Fingerprint()
+	if !strings.HasPrefix(fingerprint, "sha256:") || len(fingerprint) != len("sha256:")+2*fingerprintSize {
+		t.Errorf("Got fingerprint %q, expected sha256:<%d hex digits>", fingerprint, 2*fingerprintSize)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"psk":"` + fingerprint + `"}`; string(b) != want {
+		t.Errorf("Got JSON %s, expected %s", b, want)
+	}
+
+	for _, format := range []string{"%s", "%v", "%+v", "%#v", "%x", "%X", "%q", "%d"} {
+		s := fmt.Sprintf(format, v.PSK)
+		if s != fingerprint {
+			t.Errorf("Got %q from %s, expected %q", s, format, fingerprint)
+		}
+	}
+
+	var flagKey Key
+	if err = flagKey.Set(encoded); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(flagKey, v.PSK) {
+		t.Error("Expected the flag value to decode to the same key")
+	}
+
+	if s := Key(nil).String(); s != "" {
+		t.Errorf("Got %q from the empty key, expected the empty string", s)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package secret
+
+import "io/fs"
+
+// checkPermissions is a no-op on platforms without Unix permission bits.
+func checkPermissions(fi fs.FileInfo) error {
+	return nil
+}
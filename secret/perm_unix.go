@@ -0,0 +1,16 @@
+//go:build unix
+
+package secret
+
+import "io/fs"
+
+// unsafePermissions are the permission bits that make a secret file unsafe:
+// write by group, and any access by others.
+const unsafePermissions fs.FileMode = 0o027
+
+func checkPermissions(fi fs.FileInfo) error {
+	if fi.Mode().Perm()&unsafePermissions != 0 {
+		return ErrUnsafePermissions
+	}
+	return nil
+}
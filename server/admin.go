@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// startAdminServer starts the admin API on a Unix domain socket at path.
+//
+// A stale socket file at path is removed first.
+// The socket is only accessible by the owner, from the moment it is created.
+// The directory of the socket must not be writable by group or others.
+//
+// The caller must hold m.mu.
+func (m *Manager) startAdminServer(ctx context.Context, path string) error {
+	if err := checkAdminSocketDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	if fi, err := os.Lstat(path); err == nil && fi.Mode().Type() == fs.ModeSocket {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", m.handleAdminStatus)
+	mux.HandleFunc("GET /listeners", m.handleAdminListeners)
+	mux.HandleFunc("GET /keys", m.handleAdminKeys)
+	mux.HandleFunc("POST /servers/{server}/keys/{key}/revoke", m.handleAdminRevokeKey)
+	mux.HandleFunc("GET /replay-cache", m.handleAdminReplayCache)
+	mux.HandleFunc("GET /errors", m.handleAdminErrors)
+	mux.HandleFunc("GET /clients", m.handleAdminClients)
+	mux.HandleFunc("GET /events", m.handleAdminEvents)
+	mux.Handle("/log-level", m.logLevel)
+
+	ln, err := listenOwnerOnly(ctx, path)
+	if err != nil {
+		return err
+	}
+	m.adminService = serveHTTPService(ln, path, mux, m.logger)
+
+	m.logger.Info("Started admin server", zap.String("socketPath", path))
+	return nil
+}
+
+// stopAdminServer stops the admin server, if it is running.
+//
+// The caller must hold m.mu.
+func (m *Manager) stopAdminServer() {
+	if m.adminService == nil {
+		return
+	}
+	if err := m.adminService.Stop(); err != nil {
+		m.logger.Warn("Failed to stop admin server", zap.Error(err))
+	}
+	m.adminService = nil
+	m.logger.Info("Stopped admin server")
+}
+
+// writeAdminJSON writes v as the JSON response body.
+func (m *Manager) writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		m.logger.Debug("Failed to write admin response", zap.Error(err))
+	}
+}
+
+// writeAdminError writes err as a JSON error response.
+func (m *Manager) writeAdminError(w http.ResponseWriter, status int, err error) {
+	m.writeAdminJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// snapshotServers returns a copy of the managed servers.
+func (m *Manager) snapshotServers() []*Server {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.servers)
+}
+
+type adminStatus struct {
+	StartedAt        time.Time `json:"startedAt"`
+	Uptime           string    `json:"uptime"`
+	LogLevel         string    `json:"logLevel"`
+	Servers          int       `json:"servers"`
+	RequestsHandled  uint64    `json:"requestsHandled"`
+	RequestFailures  uint64    `json:"requestFailures"`
+	RateLimited      uint64    `json:"rateLimited"`
+	EventSubscribers int32     `json:"eventSubscribers"`
+}
+
+func (m *Manager) handleAdminStatus(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	stats := m.stats()
+	status := adminStatus{
+		StartedAt:        m.startedAt,
+		Uptime:           time.Since(m.startedAt).Round(time.Second).String(),
+		LogLevel:         m.logLevel.Level().String(),
+		Servers:          stats.Servers,
+		RequestsHandled:  stats.RequestsHandled,
+		RequestFailures:  stats.RequestFailures,
+		RateLimited:      stats.RateLimited,
+		EventSubscribers: m.events.count.Load(),
+	}
+	m.mu.Unlock()
+
+	m.writeAdminJSON(w, http.StatusOK, status)
+}
+
+type adminListener struct {
+	Server         string          `json:"server"`
+	ListenAddress  string          `json:"listenAddress"`
+	LocalAddress   string          `json:"localAddress,omitempty"`
+	AllowedClients []netip.Prefix  `json:"allowedClients,omitempty"`
+	RateLimit      RateLimitConfig `json:"rateLimit"`
+	AccessLog      bool            `json:"accessLog"`
+}
+
+func (m *Manager) handleAdminListeners(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	listeners := make([]adminListener, len(m.servers))
+	for i, s := range m.servers {
+		policy := s.policy.Load()
+		listeners[i] = adminListener{
+			Server:         s.name,
+			ListenAddress:  s.listenAddress,
+			AllowedClients: policy.allowedClients,
+			RateLimit:      policy.rateLimit,
+			AccessLog:      s.accessLog.Load() != nil,
+		}
+		if s.serverConn != nil {
+			listeners[i].LocalAddress = s.serverConn.LocalAddr().String()
+		}
+	}
+	m.mu.Unlock()
+
+	m.writeAdminJSON(w, http.StatusOK, listeners)
+}
+
+type adminKey struct {
+	Server   string `json:"server"`
+	Key      string `json:"key"`
+	Revoked  bool   `json:"revoked"`
+	Requests uint64 `json:"requests"`
+}
+
+func (m *Manager) handleAdminKeys(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	keys := make([]adminKey, 0, len(m.config.Servers))
+	for i := range m.config.Servers {
+		sc := &m.config.Servers[i]
+		serverName := sc.name()
+		j := slices.IndexFunc(m.servers, func(s *Server) bool { return s.name == serverName })
+		if j == -1 {
+			continue
+		}
+		s := m.servers[j]
+		for _, keyName := range sc.keyNames() {
+			_, revoked := m.revokedKeys[serverName][keyName]
+			keys = append(keys, adminKey{
+				Server:   serverName,
+				Key:      keyName,
+				Revoked:  revoked,
+				Requests: s.metrics.keyRequestCounter(keyName).Load(),
+			})
+		}
+	}
+	m.mu.Unlock()
+
+	m.writeAdminJSON(w, http.StatusOK, keys)
+}
+
+func (m *Manager) handleAdminRevokeKey(w http.ResponseWriter, r *http.Request) {
+	switch err := m.RevokeKey(r.PathValue("server"), r.PathValue("key")); {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, ErrServerNotFound), errors.Is(err, ErrKeyNotFound):
+		m.writeAdminError(w, http.StatusNotFound, err)
+	default:
+		m.writeAdminError(w, http.StatusConflict, err)
+	}
+}
+
+type adminReplayCache struct {
+	Server string `json:"server"`
+	Size   int64  `json:"size"`
+}
+
+func (m *Manager) handleAdminReplayCache(w http.ResponseWriter, _ *http.Request) {
+	servers := m.snapshotServers()
+	caches := make([]adminReplayCache, len(servers))
+	for i, s := range servers {
+		caches[i] = adminReplayCache{
+			Server: s.name,
+			Size:   s.replayCacheSize(),
+		}
+	}
+	m.writeAdminJSON(w, http.StatusOK, caches)
+}
+
+func (m *Manager) handleAdminErrors(w http.ResponseWriter, _ *http.Request) {
+	m.writeAdminJSON(w, http.StatusOK, m.recentErrors.List())
+}
+
+// handleAdminClients serves the client inventory.
+// The optional server and key query parameters filter the records.
+func (m *Manager) handleAdminClients(w http.ResponseWriter, r *http.Request) {
+	serverName := r.URL.Query().Get("server")
+	keyName := r.URL.Query().Get("key")
+	clients := slices.DeleteFunc(m.inventory.List(), func(c clientRecord) bool {
+		return (serverName != "" && c.Server != serverName) || (keyName != "" && c.Key != keyName)
+	})
+	m.writeAdminJSON(w, http.StatusOK, clients)
+}
+
+// handleAdminEvents streams request events as JSON lines until the client disconnects.
+func (m *Manager) handleAdminEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	events, cancel := m.events.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/jsonl")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			if err := enc.Encode(event); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package server
+
+import (
+	"context"
+	"net"
+)
+
+// checkAdminSocketDir is a no-op on platforms without Unix permission bits.
+func checkAdminSocketDir(dir string) error {
+	return nil
+}
+
+// listenOwnerOnly listens on a Unix domain socket at path.
+// Access is controlled by the platform's defaults.
+func listenOwnerOnly(ctx context.Context, path string) (net.Listener, error) {
+	var lc net.ListenConfig
+	return lc.Listen(ctx, "unix", path)
+}
@@ -0,0 +1,77 @@
+//go:build unix
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+var ErrUnsafeAdminSocketDir = errors.New("admin socket directory is writable by group or others")
+
+// checkAdminSocketDir returns an error if the directory is writable by group or others,
+// who could then replace the admin socket.
+func checkAdminSocketDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("%w: %s (%s)", ErrUnsafeAdminSocketDir, dir, fi.Mode().Perm())
+	}
+	return nil
+}
+
+// listenOwnerOnly listens on a Unix domain socket at path that only the owner can connect to.
+//
+// The socket is created in a private directory next to path, where nobody else can reach it,
+// restricted to the owner, and then moved into place.
+// This avoids changing the process-wide umask, which would affect files created by other goroutines.
+func listenOwnerOnly(ctx context.Context, path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".opdt-go-admin-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, "admin.sock")
+
+	var lc net.ListenConfig
+	ln, err := lc.Listen(ctx, "unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	ul := ln.(*net.UnixListener)
+
+	// The listener would unlink tmpPath on close, which no longer exists after the rename.
+	ul.SetUnlinkOnClose(false)
+
+	if err = os.Chmod(tmpPath, 0o600); err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		ul.Close()
+		return nil, err
+	}
+
+	return &unlinkOnCloseListener{UnixListener: ul, path: path}, nil
+}
+
+// unlinkOnCloseListener is a Unix domain socket listener that removes the socket at path when closed.
+type unlinkOnCloseListener struct {
+	*net.UnixListener
+	path string
+}
+
+// Close implements [net.Listener.Close].
+func (l *unlinkOnCloseListener) Close() error {
+	err := l.UnixListener.Close()
+	if removeErr := os.Remove(l.path); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+		err = errors.Join(err, removeErr)
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+//go:build unix
+
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestAdminSocketPermissions(t *testing.T) {
+	dir := t.TempDir()
+	newConfig := func(path string) Config {
+		return Config{
+			Servers: []ServerConfig{
+				{
+					Name:          "test",
+					ListenAddress: "127.0.0.1:0",
+					Keys:          []KeyConfig{{PSK: newTestPSK()}},
+				},
+			},
+			AdminSocketPath: path,
+		}
+	}
+
+	path := filepath.Join(dir, "admin.sock")
+	m, err := newConfig(path).Manager(zap.NewNop(), zap.NewAtomicLevel())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Start(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Got admin socket permissions %s, expected %s", perm, os.FileMode(0o600))
+	}
+
+	// The private directory the socket was created in is removed.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Got %d entries in the socket directory, expected only the socket", len(entries))
+	}
+
+	unsafeDir := filepath.Join(dir, "unsafe")
+	if err = os.Mkdir(unsafeDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chmod(unsafeDir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	um, err := newConfig(filepath.Join(unsafeDir, "admin.sock")).Manager(zap.NewNop(), zap.NewAtomicLevel())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = um.Start(t.Context()); !errors.Is(err, ErrUnsafeAdminSocketDir) {
+		um.Stop()
+		t.Fatalf("Got error %v, expected %v", err, ErrUnsafeAdminSocketDir)
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/database64128/opdt-go/accesslog"
+)
+
+// eventSubscriberBufferSize is the number of events buffered for each subscriber.
+// Events are dropped for subscribers that fall behind.
+const eventSubscriberBufferSize = 256
+
+// eventBroker fans out request events to subscribers.
+//
+// eventBroker is safe for concurrent use.
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan accesslog.Entry]struct{}
+	count       atomic.Int32
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subscribers: make(map[chan accesslog.Entry]struct{}),
+	}
+}
+
+// Subscribe returns a channel that receives published events,
+// and a function that cancels the subscription.
+func (b *eventBroker) Subscribe() (<-chan accesslog.Entry, func()) {
+	ch := make(chan accesslog.Entry, eventSubscriberBufferSize)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.count.Add(1)
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.count.Add(-1)
+		b.mu.Unlock()
+	}
+}
+
+// HasSubscribers returns whether there is at least one subscriber.
+func (b *eventBroker) HasSubscribers() bool {
+	return b.count.Load() > 0
+}
+
+// Publish sends the event to all subscribers without blocking.
+func (b *eventBroker) Publish(entry accesslog.Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- entry:
+		default:
+		}
+	}
+}
+
+// recentErrorsCapacity is the number of recent errors kept for introspection.
+const recentErrorsCapacity = 64
+
+// recentError is an error that occurred while receiving, handling, or responding to a request.
+type recentError struct {
+	Time          time.Time      `json:"time"`
+	Server        string         `json:"server"`
+	ClientAddress netip.AddrPort `json:"client,omitzero"`
+	Cause         string         `json:"cause"`
+	Error         string         `json:"error"`
+}
+
+// errorRing keeps the most recent errors.
+//
+// errorRing is safe for concurrent use.
+type errorRing struct {
+	mu   sync.Mutex
+	buf  [recentErrorsCapacity]recentError
+	next int
+	full bool
+}
+
+// Add adds the error to the ring, overwriting the oldest error if the ring is full.
+func (r *errorRing) Add(e recentError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf[r.next] = e
+	r.next++
+	if r.next == len(r.buf) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// List returns the errors in the ring, oldest first.
+func (r *errorRing) List() []recentError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append(make([]recentError, 0, r.next), r.buf[:r.next]...)
+	}
+	errs := make([]recentError, 0, len(r.buf))
+	errs = append(errs, r.buf[r.next:]...)
+	return append(errs, r.buf[:r.next]...)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// httpService is an HTTP server running in the background.
+type httpService struct {
+	address string
+	server  *http.Server
+	wg      sync.WaitGroup
+}
+
+// startHTTPService listens on the address and serves handler in the background.
+func startHTTPService(ctx context.Context, network, address string, handler http.Handler, logger *zap.Logger) (*httpService, error) {
+	var lc net.ListenConfig
+	ln, err := lc.Listen(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return serveHTTPService(ln, address, handler, logger), nil
+}
+
+// serveHTTPService serves handler on the listener in the background.
+func serveHTTPService(ln net.Listener, address string, handler http.Handler, logger *zap.Logger) *httpService {
+	hs := &httpService{
+		address: address,
+		server: &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: 10 * time.Second,
+			ErrorLog:          zap.NewStdLog(logger),
+		},
+	}
+
+	hs.wg.Go(func() {
+		if err := hs.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Error("Failed to serve HTTP", zap.String("address", address), zap.Error(err))
+		}
+	})
+
+	return hs
+}
+
+// Address returns the address the service listens on, or an empty string if hs is nil.
+func (hs *httpService) Address() string {
+	if hs == nil {
+		return ""
+	}
+	return hs.address
+}
+
+// Stop closes the listener and all active connections, and waits for the server to stop.
+func (hs *httpService) Stop() error {
+	err := hs.server.Close()
+	hs.wg.Wait()
+	return err
+}
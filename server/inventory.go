@@ -0,0 +1,276 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/database64128/opdt-go/jsonhelper"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultInventorySaveInterval = time.Minute
+	defaultInventoryMaxHistory   = 16
+)
+
+// InventoryConfig is the configuration of the recent-clients inventory.
+//
+// The inventory keeps, for each server and key, the last observed client address,
+// and is always maintained in memory.
+type InventoryConfig struct {
+	// Path is the path to the file the inventory is persisted to.
+	// The inventory is loaded from the file on start, and saved periodically and on stop.
+	// If empty, the inventory is not persisted.
+	Path string `json:"path,omitempty"`
+
+	// SaveInterval is the interval between saves of a changed inventory.
+	// If zero, the inventory is saved every minute.
+	SaveInterval jsonhelper.Duration `json:"saveInterval,omitempty"`
+
+	// MaxHistory is the maximum number of mappings kept in each client's history.
+	// If zero, 16 mappings are kept.
+	MaxHistory int `json:"maxHistory,omitempty"`
+}
+
+func (c *InventoryConfig) saveInterval() time.Duration {
+	if c.SaveInterval > 0 {
+		return time.Duration(c.SaveInterval)
+	}
+	return defaultInventorySaveInterval
+}
+
+func (c *InventoryConfig) maxHistory() int {
+	if c.MaxHistory > 0 {
+		return c.MaxHistory
+	}
+	return defaultInventoryMaxHistory
+}
+
+// clientIdentity identifies a client by the server it queried and the key it authenticated with.
+type clientIdentity struct {
+	server string
+	key    string
+}
+
+// clientMapping is a client's public address, as observed by the server,
+// and its local address, if the client sent one.
+type clientMapping struct {
+	Since         time.Time      `json:"since"`
+	ClientAddress netip.AddrPort `json:"client"`
+	LocalAddress  netip.AddrPort `json:"local,omitzero"`
+}
+
+// clientRecord is an entry of the client inventory.
+type clientRecord struct {
+	Server        string          `json:"server"`
+	Key           string          `json:"key"`
+	ClientAddress netip.AddrPort  `json:"client"`
+	LocalAddress  netip.AddrPort  `json:"local,omitzero"`
+	FirstSeen     time.Time       `json:"firstSeen"`
+	LastSeen      time.Time       `json:"lastSeen"`
+	Requests      uint64          `json:"requests"`
+	History       []clientMapping `json:"history"`
+}
+
+// inventoryFile is the on-disk format of the client inventory.
+type inventoryFile struct {
+	Clients []clientRecord `json:"clients"`
+}
+
+// clientInventory tracks the last observed address of each client identity,
+// and the history of its mapping changes.
+//
+// clientInventory is safe for concurrent use.
+type clientInventory struct {
+	mu         sync.Mutex
+	clients    map[clientIdentity]*clientRecord
+	maxHistory int
+	dirty      bool
+}
+
+func newClientInventory(maxHistory int) *clientInventory {
+	return &clientInventory{
+		clients:    make(map[clientIdentity]*clientRecord),
+		maxHistory: maxHistory,
+	}
+}
+
+// SetMaxHistory changes the maximum number of mappings kept in each client's history.
+// Histories longer than the new limit are trimmed on the next change.
+func (inv *clientInventory) SetMaxHistory(n int) {
+	inv.mu.Lock()
+	inv.maxHistory = n
+	inv.mu.Unlock()
+}
+
+// Observe records an authenticated request from the client identified by server and key.
+func (inv *clientInventory) Observe(server, key string, clientAddrPort, localAddrPort netip.AddrPort, now time.Time) {
+	id := clientIdentity{server: server, key: key}
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	inv.dirty = true
+
+	r, ok := inv.clients[id]
+	if !ok {
+		inv.clients[id] = &clientRecord{
+			Server:        server,
+			Key:           key,
+			ClientAddress: clientAddrPort,
+			LocalAddress:  localAddrPort,
+			FirstSeen:     now,
+			LastSeen:      now,
+			Requests:      1,
+			History: []clientMapping{{
+				Since:         now,
+				ClientAddress: clientAddrPort,
+				LocalAddress:  localAddrPort,
+			}},
+		}
+		return
+	}
+
+	r.LastSeen = now
+	r.Requests++
+
+	if r.ClientAddress == clientAddrPort && r.LocalAddress == localAddrPort {
+		return
+	}
+
+	r.ClientAddress = clientAddrPort
+	r.LocalAddress = localAddrPort
+	r.History = append(r.History, clientMapping{
+		Since:         now,
+		ClientAddress: clientAddrPort,
+		LocalAddress:  localAddrPort,
+	})
+	if excess := len(r.History) - inv.maxHistory; excess > 0 {
+		r.History = slices.Delete(r.History, 0, excess)
+	}
+}
+
+// List returns a copy of all records, sorted by server and key.
+func (inv *clientInventory) List() []clientRecord {
+	inv.mu.Lock()
+	records := make([]clientRecord, 0, len(inv.clients))
+	for _, r := range inv.clients {
+		record := *r
+		record.History = slices.Clone(r.History)
+		records = append(records, record)
+	}
+	inv.mu.Unlock()
+
+	slices.SortFunc(records, func(a, b clientRecord) int {
+		return cmp.Or(cmp.Compare(a.Server, b.Server), cmp.Compare(a.Key, b.Key))
+	})
+	return records
+}
+
+// Load replaces the inventory with the records in the file at path.
+// A missing file is not an error.
+func (inv *clientInventory) Load(path string) error {
+	var f inventoryFile
+	if err := jsonhelper.OpenAndDecodeDisallowUnknownFields(path, &f); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	clients := make(map[clientIdentity]*clientRecord, len(f.Clients))
+	for i := range f.Clients {
+		r := &f.Clients[i]
+		clients[clientIdentity{server: r.Server, key: r.Key}] = r
+	}
+
+	inv.mu.Lock()
+	inv.clients = clients
+	inv.dirty = false
+	inv.mu.Unlock()
+	return nil
+}
+
+// Save writes the inventory to the file at path, if it has changed since the last save or load.
+// If force is true, the inventory is written even if it has not changed.
+func (inv *clientInventory) Save(path string, force bool) error {
+	inv.mu.Lock()
+	dirty := inv.dirty
+	inv.dirty = false
+	inv.mu.Unlock()
+
+	if !dirty && !force {
+		return nil
+	}
+
+	if err := jsonhelper.EncodeAndWriteFileAtomic(path, inventoryFile{Clients: inv.List()}, 0o600); err != nil {
+		inv.mu.Lock()
+		inv.dirty = true
+		inv.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// inventorySaver periodically saves the client inventory to a file.
+type inventorySaver struct {
+	path string
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// startInventorySaver starts saving the inventory to the configured path, if any.
+//
+// The caller must hold m.mu.
+func (m *Manager) startInventorySaver() {
+	c := &m.config.Inventory
+	if c.Path == "" {
+		return
+	}
+
+	saver := &inventorySaver{
+		path: c.Path,
+		done: make(chan struct{}),
+	}
+	interval := c.saveInterval()
+
+	saver.wg.Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-saver.done:
+				return
+			case <-ticker.C:
+				if err := m.inventory.Save(saver.path, false); err != nil {
+					m.logger.Warn("Failed to save client inventory", zap.String("path", saver.path), zap.Error(err))
+				}
+			}
+		}
+	})
+
+	m.inventorySaver = saver
+}
+
+// stopInventorySaver stops the inventory saver, if running, and saves the inventory one last time.
+//
+// The caller must hold m.mu.
+func (m *Manager) stopInventorySaver() {
+	saver := m.inventorySaver
+	if saver == nil {
+		return
+	}
+	close(saver.done)
+	saver.wg.Wait()
+	m.inventorySaver = nil
+
+	if err := m.inventory.Save(saver.path, false); err != nil {
+		m.logger.Warn("Failed to save client inventory", zap.String("path", saver.path), zap.Error(err))
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientInventory(t *testing.T) {
+	inv := newClientInventory(2)
+	now := time.Now().Truncate(time.Second)
+
+	addrA := netip.MustParseAddrPort("192.0.2.1:10000")
+	addrB := netip.MustParseAddrPort("192.0.2.1:20000")
+	addrC := netip.MustParseAddrPort("198.51.100.1:30000")
+	local := netip.MustParseAddrPort("10.0.0.2:10128")
+
+	inv.Observe("v4", "alice", addrA, netip.AddrPort{}, now)
+	inv.Observe("v4", "alice", addrA, netip.AddrPort{}, now.Add(time.Second))
+	inv.Observe("v4", "alice", addrB, local, now.Add(2*time.Second))
+	inv.Observe("v4", "alice", addrC, local, now.Add(3*time.Second))
+	inv.Observe("v4", "bob", addrA, netip.AddrPort{}, now)
+
+	records := inv.List()
+	if len(records) != 2 {
+		t.Fatalf("Got %d records, expected 2", len(records))
+	}
+
+	alice := records[0]
+	if alice.Key != "alice" {
+		t.Fatalf("Got first record for key %q, expected %q", alice.Key, "alice")
+	}
+	if alice.ClientAddress != addrC || alice.LocalAddress != local {
+		t.Errorf("Got mapping %s -> %s, expected %s -> %s", alice.LocalAddress, alice.ClientAddress, local, addrC)
+	}
+	if alice.Requests != 4 {
+		t.Errorf("Got %d requests, expected 4", alice.Requests)
+	}
+	if !alice.FirstSeen.Equal(now) || !alice.LastSeen.Equal(now.Add(3*time.Second)) {
+		t.Errorf("Got first seen %s and last seen %s", alice.FirstSeen, alice.LastSeen)
+	}
+	if len(alice.History) != 2 || alice.History[0].ClientAddress != addrB || alice.History[1].ClientAddress != addrC {
+		t.Errorf("Got history %v, expected the last 2 mappings", alice.History)
+	}
+
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	if err := inv.Save(path, false); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newClientInventory(2)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	loadedRecords := loaded.List()
+	if len(loadedRecords) != len(records) {
+		t.Fatalf("Got %d loaded records, expected %d", len(loadedRecords), len(records))
+	}
+	if got := loadedRecords[0]; got.ClientAddress != addrC || got.Requests != 4 || len(got.History) != 2 {
+		t.Errorf("Got loaded record %+v, expected %+v", got, alice)
+	}
+}
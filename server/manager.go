@@ -0,0 +1,538 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/database64128/opdt-go/accesslog"
+	"github.com/database64128/opdt-go/secret"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrServerNotFound = errors.New("server not found")
+	ErrKeyNotFound    = errors.New("key not found")
+)
+
+// Config is the configuration of all server instances managed by a [Manager].
+type Config struct {
+	Servers []ServerConfig `json:"servers"`
+
+	// ListenAddress and PSK are the settings of the single server
+	// in the config format from before multiple server instances were supported.
+	//
+	// Deprecated: Use Servers. [Config.UpgradeLegacy] converts them into a server instance.
+	ListenAddress string     `json:"listen,omitempty"`
+	PSK           secret.Key `json:"psk,omitempty"`
+
+	// MetricsListenAddress is the TCP address of the HTTP server that serves
+	// Prometheus metrics at /metrics. If empty, metrics are not served.
+	MetricsListenAddress string `json:"metricsListen,omitempty"`
+
+	// AccessLog is the configuration of the access log.
+	// If nil, the access log is disabled.
+	AccessLog *accesslog.Config `json:"accessLog,omitempty"`
+
+	// AdminSocketPath is the path to the Unix domain socket of the admin API.
+	// If empty, the admin API is disabled.
+	AdminSocketPath string `json:"adminSocket,omitempty"`
+
+	// Inventory is the configuration of the recent-clients inventory.
+	Inventory InventoryConfig `json:"inventory,omitzero"`
+}
+
+// LegacyServerName is the name of the server instance converted from a legacy config.
+const LegacyServerName = "default"
+
+// UpgradeLegacy converts the deprecated top-level listen and psk settings
+// into a server instance with a single key, and reports whether it did.
+// A config that also has Servers is left unchanged, so that [Config.Validate] reports the conflict.
+func (c *Config) UpgradeLegacy() bool {
+	if c.ListenAddress == "" && len(c.PSK) == 0 || len(c.Servers) > 0 {
+		return false
+	}
+	c.Servers = []ServerConfig{
+		{
+			Name:          LegacyServerName,
+			ListenAddress: c.ListenAddress,
+			Keys:          []KeyConfig{{Name: LegacyServerName, PSK: c.PSK}},
+		},
+	}
+	c.ListenAddress = ""
+	c.PSK = nil
+	return true
+}
+
+// checkNames returns an error if two server instances share the same name.
+func (c *Config) checkNames() error {
+	names := make(map[string]struct{}, len(c.Servers))
+	for i := range c.Servers {
+		name := c.Servers[i].name()
+		if _, ok := names[name]; ok {
+			return fmt.Errorf("duplicate server name %q", name)
+		}
+		names[name] = struct{}{}
+	}
+	return nil
+}
+
+// KeyFingerprint is the fingerprint of a configured key.
+type KeyFingerprint struct {
+	Server      string `json:"server"`
+	Key         string `json:"key"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// KeyFingerprints returns the fingerprints of all configured keys,
+// so that they can be checked against the keys of clients without revealing them.
+func (c *Config) KeyFingerprints() ([]KeyFingerprint, error) {
+	var fingerprints []KeyFingerprint
+	for i := range c.Servers {
+		sc := &c.Servers[i]
+		for j := range sc.Keys {
+			key, err := sc.Keys[j].Key()
+			if err != nil {
+				return nil, fmt.Errorf("server %q: key %q: %w", sc.name(), sc.keyName(j), err)
+			}
+			fingerprints = append(fingerprints, KeyFingerprint{
+				Server:      sc.name(),
+				Key:         sc.keyName(j),
+				Fingerprint: key.Fingerprint(),
+			})
+		}
+	}
+	return fingerprints, nil
+}
+
+// Manager creates a new manager for the configured server instances.
+//
+// logLevel is the level of logger, and can be changed through the admin API.
+func (c Config) Manager(logger *zap.Logger, logLevel zap.AtomicLevel) (*Manager, error) {
+	if err := c.checkNames(); err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		config:       c,
+		servers:      make([]*Server, len(c.Servers)),
+		revokedKeys:  make(map[string]map[string]struct{}),
+		events:       newEventBroker(),
+		recentErrors: new(errorRing),
+		inventory:    newClientInventory(c.Inventory.maxHistory()),
+		logLevel:     logLevel,
+		logger:       logger,
+	}
+
+	for i := range c.Servers {
+		s, err := c.Servers[i].Server(logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create server %q: %w", c.Servers[i].name(), err)
+		}
+		m.attach(s)
+		m.servers[i] = s
+	}
+
+	return m, nil
+}
+
+// Manager manages a group of server instances that start and stop together.
+type Manager struct {
+	mu             sync.Mutex
+	config         Config
+	servers        []*Server
+	revokedKeys    map[string]map[string]struct{}
+	startedAt      time.Time
+	metricsService *httpService
+	adminService   *httpService
+	accessLog      *accesslog.Logger
+	events         *eventBroker
+	recentErrors   *errorRing
+	inventory      *clientInventory
+	inventorySaver *inventorySaver
+	logLevel       zap.AtomicLevel
+	logger         *zap.Logger
+}
+
+// attach connects the server to the manager's event broker, recent errors and client inventory.
+func (m *Manager) attach(s *Server) {
+	s.events = m.events
+	s.recentErrors = m.recentErrors
+	s.inventory = m.inventory
+}
+
+// Start starts all servers. If any server fails to start,
+// the servers that have already started are stopped.
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if path := m.config.Inventory.Path; path != "" {
+		if err := m.inventory.Load(path); err != nil {
+			m.logger.Warn("Failed to load client inventory, starting empty", zap.String("path", path), zap.Error(err))
+		}
+	}
+
+	if m.config.AccessLog != nil {
+		accessLog, err := m.config.AccessLog.Open(m.logAccessLogError)
+		if err != nil {
+			return fmt.Errorf("failed to open access log: %w", err)
+		}
+		m.accessLog = accessLog
+		for _, s := range m.servers {
+			s.accessLog.Store(accessLog)
+		}
+	}
+
+	for i, s := range m.servers {
+		if err := s.Start(ctx); err != nil {
+			for _, started := range m.servers[:i] {
+				started.Stop()
+			}
+			m.closeAccessLog()
+			return fmt.Errorf("failed to start server %q: %w", s.name, err)
+		}
+		m.logger.Info("Started server",
+			zap.String("server", s.name),
+			zap.String("listenAddress", s.listenAddress),
+			zap.Stringer("localAddress", s.serverConn.LocalAddr()),
+		)
+	}
+
+	m.startedAt = time.Now()
+	m.startInventorySaver()
+
+	if err := m.startHTTPServices(ctx); err != nil {
+		m.stopHTTPServices()
+		for _, s := range m.servers {
+			s.Stop()
+		}
+		m.stopInventorySaver()
+		m.closeAccessLog()
+		return err
+	}
+
+	return nil
+}
+
+// startHTTPServices starts or restarts the metrics and admin servers
+// whose configured address differs from the running one.
+//
+// The caller must hold m.mu.
+func (m *Manager) startHTTPServices(ctx context.Context) error {
+	var errs []error
+
+	if m.config.MetricsListenAddress != m.metricsService.Address() {
+		m.stopMetricsServer()
+		if m.config.MetricsListenAddress != "" {
+			if err := m.startMetricsServer(ctx, m.config.MetricsListenAddress); err != nil {
+				errs = append(errs, fmt.Errorf("failed to start metrics server: %w", err))
+			}
+		}
+	}
+
+	if m.config.AdminSocketPath != m.adminService.Address() {
+		m.stopAdminServer()
+		if m.config.AdminSocketPath != "" {
+			if err := m.startAdminServer(ctx, m.config.AdminSocketPath); err != nil {
+				errs = append(errs, fmt.Errorf("failed to start admin server: %w", err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// stopHTTPServices stops the metrics and admin servers, if running.
+//
+// The caller must hold m.mu.
+func (m *Manager) stopHTTPServices() {
+	m.stopMetricsServer()
+	m.stopAdminServer()
+}
+
+// Reload applies the new configuration to the running servers.
+//
+// Keys, access control lists and rate limits of existing servers are swapped atomically,
+// and replay protection state is kept. Servers are matched by name.
+// Only servers whose listen address changed are rebuilt.
+// Servers missing from the new configuration are stopped, and new servers are started.
+// Keys revoked through [Manager.RevokeKey] stay revoked.
+//
+// The new configuration is validated in full before any change is made.
+// If a rebuilt or new server fails to listen, the old server, if any, is kept running
+// on its old address with the new policy, and the error is returned
+// after the rest of the configuration has been applied.
+func (m *Manager) Reload(ctx context.Context, c Config) error {
+	if err := c.checkNames(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldServers := make(map[string]*Server, len(m.servers))
+	for _, s := range m.servers {
+		oldServers[s.name] = s
+	}
+
+	// Build all policies first, so that an invalid configuration changes nothing.
+	policies := make([]*serverPolicy, len(c.Servers))
+	metrics := make([]*serverMetrics, len(c.Servers))
+	for i := range c.Servers {
+		sc := &c.Servers[i]
+		var prev *serverPolicy
+		if old, ok := oldServers[sc.name()]; ok {
+			prev = old.policy.Load()
+			metrics[i] = old.metrics
+		} else {
+			metrics[i] = newServerMetrics()
+		}
+		policy, err := sc.policy(prev, metrics[i], m.revokedKeys[sc.name()])
+		if err != nil {
+			return fmt.Errorf("failed to create server %q: %w", sc.name(), err)
+		}
+		policies[i] = policy
+	}
+
+	// Open the new access log before touching any server, so that a failure changes nothing.
+	accessLog := m.accessLog
+	accessLogChanged := !accessLogConfigEqual(m.config.AccessLog, c.AccessLog)
+	if accessLogChanged {
+		accessLog = nil
+		if c.AccessLog != nil {
+			var err error
+			if accessLog, err = c.AccessLog.Open(m.logAccessLogError); err != nil {
+				return fmt.Errorf("failed to open access log: %w", err)
+			}
+		}
+	}
+
+	// Stop removed servers first, so that their addresses can be reused.
+	for name, s := range oldServers {
+		if slices.ContainsFunc(c.Servers, func(sc ServerConfig) bool { return sc.name() == name }) {
+			continue
+		}
+		delete(oldServers, name)
+		delete(m.revokedKeys, name)
+		if err := s.Stop(); err != nil {
+			m.logger.Warn("Failed to stop server", zap.String("server", name), zap.Error(err))
+			continue
+		}
+		m.logger.Info("Stopped server", zap.String("server", name))
+	}
+
+	var errs []error
+	servers := make([]*Server, 0, len(c.Servers))
+
+	for i := range c.Servers {
+		sc := &c.Servers[i]
+		name := sc.name()
+		old, ok := oldServers[name]
+
+		if ok && old.listenAddress == sc.ListenAddress {
+			old.accessLog.Store(accessLog)
+			old.policy.Store(policies[i])
+			servers = append(servers, old)
+			m.logger.Info("Reloaded server", zap.String("server", name))
+			continue
+		}
+
+		s := newServer(name, sc.ListenAddress, policies[i], metrics[i], m.logger)
+		m.attach(s)
+		s.accessLog.Store(accessLog)
+		if err := s.listen(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to start server %q: %w", name, err))
+			if ok {
+				old.accessLog.Store(accessLog)
+				old.policy.Store(policies[i])
+				servers = append(servers, old)
+			}
+			continue
+		}
+
+		// The new policy shares the nonce pool with the old server,
+		// so the old server must be stopped before the new one starts receiving.
+		if ok {
+			if err := old.Stop(); err != nil {
+				m.logger.Warn("Failed to stop server", zap.String("server", name), zap.Error(err))
+			}
+		}
+
+		s.serve()
+		servers = append(servers, s)
+		m.logger.Info("Started server",
+			zap.String("server", name),
+			zap.String("listenAddress", s.listenAddress),
+			zap.Stringer("localAddress", s.serverConn.LocalAddr()),
+		)
+	}
+
+	m.servers = servers
+	oldInventoryConfig := m.config.Inventory
+	m.config = c
+
+	m.inventory.SetMaxHistory(c.Inventory.maxHistory())
+	if c.Inventory.Path != oldInventoryConfig.Path || c.Inventory.saveInterval() != oldInventoryConfig.saveInterval() {
+		m.stopInventorySaver()
+		if c.Inventory.Path != "" && c.Inventory.Path != oldInventoryConfig.Path {
+			if err := m.inventory.Save(c.Inventory.Path, true); err != nil {
+				errs = append(errs, fmt.Errorf("failed to save client inventory: %w", err))
+			}
+		}
+		m.startInventorySaver()
+	}
+
+	if accessLogChanged {
+		m.closeAccessLog()
+		m.accessLog = accessLog
+	}
+
+	if err := m.startHTTPServices(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// RevokeKey immediately stops the named server from accepting the named key.
+// The key stays revoked across reloads, until the process exits.
+func (m *Manager) RevokeKey(serverName, keyName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.servers, func(s *Server) bool { return s.name == serverName })
+	if i == -1 {
+		return fmt.Errorf("%w: %q", ErrServerNotFound, serverName)
+	}
+	s := m.servers[i]
+
+	// Running servers always have a matching configuration.
+	j := slices.IndexFunc(m.config.Servers, func(sc ServerConfig) bool { return sc.name() == serverName })
+	sc := &m.config.Servers[j]
+
+	if !slices.ContainsFunc(sc.keyNames(), func(name string) bool { return name == keyName }) {
+		return fmt.Errorf("%w: %q", ErrKeyNotFound, keyName)
+	}
+
+	revoked := make(map[string]struct{}, len(m.revokedKeys[serverName])+1)
+	for name := range m.revokedKeys[serverName] {
+		revoked[name] = struct{}{}
+	}
+	revoked[keyName] = struct{}{}
+
+	policy, err := sc.policy(s.policy.Load(), s.metrics, revoked)
+	if err != nil {
+		return err
+	}
+
+	s.policy.Store(policy)
+	m.revokedKeys[serverName] = revoked
+	m.logger.Info("Revoked key", zap.String("server", serverName), zap.String("key", keyName))
+	return nil
+}
+
+// Stats are the request counters summed over all managed servers.
+type Stats struct {
+	Servers         int
+	RequestsHandled uint64
+	RequestFailures uint64
+	RateLimited     uint64
+}
+
+// String returns a one-line summary of the stats.
+func (s Stats) String() string {
+	return fmt.Sprintf("%d servers, %d requests handled, %d failed, %d rate limited", s.Servers, s.RequestsHandled, s.RequestFailures, s.RateLimited)
+}
+
+// Stats returns the request counters summed over all managed servers.
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats()
+}
+
+// stats returns the request counters summed over all managed servers.
+//
+// The caller must hold m.mu.
+func (m *Manager) stats() Stats {
+	stats := Stats{Servers: len(m.servers)}
+	for _, s := range m.servers {
+		stats.RequestsHandled += s.metrics.requestsHandled.Load()
+		stats.RateLimited += s.metrics.rateLimited.Load()
+		for i := range s.metrics.failures {
+			stats.RequestFailures += s.metrics.failures[i].Load()
+		}
+	}
+	return stats
+}
+
+// StalledServers returns the names of the servers whose receive loop
+// has not made progress since the previous call, and wakes all receive loops,
+// so that an idle but healthy loop makes progress before the next call.
+//
+// Servers started since the previous call are not reported.
+// Call it periodically, at an interval long enough for a healthy loop to wake up.
+func (m *Manager) StalledServers() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var stalled []string
+	for _, s := range m.servers {
+		progress := s.recvProgress.Load()
+		if s.polled && progress == s.polledProgress {
+			stalled = append(stalled, s.name)
+		}
+		s.polledProgress, s.polled = progress, true
+		s.wake()
+	}
+	return stalled
+}
+
+// Stop stops all servers.
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopHTTPServices()
+
+	for _, s := range m.servers {
+		if err := s.Stop(); err != nil {
+			m.logger.Warn("Failed to stop server", zap.String("server", s.name), zap.Error(err))
+			continue
+		}
+		m.logger.Info("Stopped server", zap.String("server", s.name))
+	}
+
+	m.stopInventorySaver()
+	m.closeAccessLog()
+}
+
+// closeAccessLog closes the access log, if it is open.
+//
+// The caller must hold m.mu.
+func (m *Manager) closeAccessLog() {
+	if m.accessLog == nil {
+		return
+	}
+	if err := m.accessLog.Close(); err != nil {
+		m.logger.Warn("Failed to close access log", zap.Error(err))
+	}
+	m.accessLog = nil
+}
+
+// logAccessLogError logs an error that occurred while writing the access log.
+func (m *Manager) logAccessLogError(err error) {
+	m.logger.Warn("Failed to write access log entry", zap.Error(err))
+}
+
+// accessLogConfigEqual returns whether two optional access log configurations are equal.
+func accessLogConfigEqual(a, b *accesslog.Config) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"crypto/rand"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/database64128/opdt-go/packet"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func newTestPSK() []byte {
+	psk := make([]byte, chacha20poly1305.KeySize)
+	rand.Read(psk)
+	return psk
+}
+
+// query sends req to the server and returns the client address in the response.
+func query(t *testing.T, serverAddr net.Addr, c *packet.Client, req []byte) (netip.AddrPort, error) {
+	t.Helper()
+
+	conn, err := net.DialUDP("udp", nil, serverAddr.(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := make([]byte, packet.ResponsePacketSize)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return c.ParseResponse(resp[:n])
+}
+
+func TestManagerReload(t *testing.T) {
+	pskA, pskB := newTestPSK(), newTestPSK()
+	clientA, err := packet.NewClient(pskA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientB, err := packet.NewClient(pskB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := t.Context()
+	logger := zap.NewNop()
+
+	m, err := Config{
+		Servers: []ServerConfig{
+			{
+				Name:          "test",
+				ListenAddress: "127.0.0.1:0",
+				Keys:          []KeyConfig{{Name: "a", PSK: pskA}},
+			},
+		},
+	}.Manager(logger, zap.NewAtomicLevel())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	s := m.servers[0]
+	serverAddr := s.serverConn.LocalAddr()
+
+	reqA := make([]byte, packet.RequestPacketSize)
+	clientA.PutRequest(reqA)
+	if _, err = query(t, serverAddr, clientA, reqA); err != nil {
+		t.Fatalf("Request with key a failed: %v", err)
+	}
+	if size := s.replayCacheSize(); size != 1 {
+		t.Errorf("Got replay cache size %d, expected 1", size)
+	}
+
+	// Swap key a for key b on the same address.
+	if err = m.Reload(ctx, Config{
+		Servers: []ServerConfig{
+			{
+				Name:          "test",
+				ListenAddress: "127.0.0.1:0",
+				Keys:          []KeyConfig{{Name: "b", PSK: pskB}},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if m.servers[0] != s {
+		t.Fatal("Server with unchanged listen address was rebuilt")
+	}
+
+	reqB := make([]byte, packet.RequestPacketSize)
+	clientB.PutRequest(reqB)
+	if _, err = query(t, serverAddr, clientB, reqB); err != nil {
+		t.Fatalf("Request with key b failed after reload: %v", err)
+	}
+
+	clientA.PutRequest(reqA)
+	if _, err = query(t, serverAddr, clientA, reqA); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Request with revoked key a: got error %v, expected timeout", err)
+	}
+
+	// Move to a new address, and check that the replay window carried over.
+	if err = m.Reload(ctx, Config{
+		Servers: []ServerConfig{
+			{
+				Name:          "test",
+				ListenAddress: ":0",
+				Keys:          []KeyConfig{{Name: "b", PSK: pskB}},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if m.servers[0] == s {
+		t.Fatal("Server with changed listen address was not rebuilt")
+	}
+
+	newServerAddr := &net.UDPAddr{
+		IP:   net.IPv4(127, 0, 0, 1),
+		Port: m.servers[0].serverConn.LocalAddr().(*net.UDPAddr).Port,
+	}
+	if _, err = query(t, newServerAddr, clientB, reqB); err == nil {
+		t.Fatal("Replayed request was accepted after rebuilding the server")
+	}
+
+	clientB.PutRequest(reqB)
+	if _, err = query(t, newServerAddr, clientB, reqB); err != nil {
+		t.Fatalf("Request with key b failed after rebuilding the server: %v", err)
+	}
+
+	// Invalid configurations must not change anything.
+	if err = m.Reload(ctx, Config{
+		Servers: []ServerConfig{
+			{Name: "test", ListenAddress: "127.0.0.1:0"},
+		},
+	}); err == nil {
+		t.Fatal("Reload with no keys succeeded")
+	}
+	clientB.PutRequest(reqB)
+	if _, err = query(t, newServerAddr, clientB, reqB); err != nil {
+		t.Fatalf("Request with key b failed after invalid reload: %v", err)
+	}
+
+	// Counters survive rebuilding the server.
+	var b strings.Builder
+	if err = writeMetrics(&b, m.servers); err != nil {
+		t.Fatal(err)
+	}
+	metrics := b.String()
+	for _, line := range []string{
+		`opdt_requests_handled_total{server="test"} 4`,
+		`opdt_request_failures_total{server="test",cause="authentication_failed"} 1`,
+		`opdt_request_failures_total{server="test",cause="repeated_nonce"} 1`,
+		`opdt_key_requests_total{server="test",key="a"} 1`,
+		`opdt_key_requests_total{server="test",key="b"} 3`,
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("Metrics missing line %q:\n%s", line, metrics)
+		}
+	}
+}
+
+func TestManagerStalledServers(t *testing.T) {
+	psk := newTestPSK()
+	c, err := packet.NewClient(psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Config{
+		Servers: []ServerConfig{
+			{
+				Name:          "test",
+				ListenAddress: "127.0.0.1:0",
+				Keys:          []KeyConfig{{PSK: psk}},
+			},
+		},
+	}.Manager(zap.NewNop(), zap.NewAtomicLevel())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Start(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	s := m.servers[0]
+
+	// An idle server is woken up by each call, and is never reported.
+	for range 3 {
+		if stalled := m.StalledServers(); len(stalled) != 0 {
+			t.Fatalf("Got stalled servers %q, expected none", stalled)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	req := make([]byte, packet.RequestPacketSize)
+	c.PutRequest(req)
+	if _, err = query(t, s.serverConn.LocalAddr(), c, req); err != nil {
+		t.Fatalf("Request failed after waking up the receive loop: %v", err)
+	}
+
+	// Stop the receive loop behind the manager's back.
+	s.stopping.Store(true)
+	s.wake()
+	s.wg.Wait()
+
+	m.StalledServers()
+	if stalled := m.StalledServers(); len(stalled) != 1 || stalled[0] != "test" {
+		t.Fatalf("Got stalled servers %q, expected [\"test\"]", stalled)
+	}
+}
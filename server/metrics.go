@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/database64128/opdt-go/conn"
+	"github.com/database64128/opdt-go/packet"
+	"go.uber.org/zap"
+)
+
+// failureCause classifies why a packet did not result in a response.
+type failureCause int
+
+const (
+	causeReceiveError failureCause = iota
+	causeMessageTruncated
+	causeControlMessageTruncated
+	causeBadPacketSize
+	causeRepeatedNonce
+	causeBadTimestamp
+	causeBadMessageType
+	causeAuthenticationFailed
+	causeClientNotAllowed
+	causeSendError
+	causeOther
+	failureCauseCount
+)
+
+var failureCauseNames = [failureCauseCount]string{
+	causeReceiveError:            "receive_error",
+	causeMessageTruncated:        "message_truncated",
+	causeControlMessageTruncated: "control_message_truncated",
+	causeBadPacketSize:           "bad_packet_size",
+	causeRepeatedNonce:           "repeated_nonce",
+	causeBadTimestamp:            "bad_timestamp",
+	causeBadMessageType:          "bad_message_type",
+	causeAuthenticationFailed:    "authentication_failed",
+	causeClientNotAllowed:        "client_not_allowed",
+	causeSendError:               "send_error",
+	causeOther:                   "other",
+}
+
+// String implements [fmt.Stringer].
+func (c failureCause) String() string {
+	return failureCauseNames[c]
+}
+
+// failureCauseOf returns the failure cause of an error returned when receiving or handling a packet.
+func failureCauseOf(err error) failureCause {
+	switch {
+	case errors.Is(err, conn.ErrMessageTruncated):
+		return causeMessageTruncated
+	case errors.Is(err, conn.ErrControlMessageTruncated):
+		return causeControlMessageTruncated
+	case errors.Is(err, packet.ErrBadPacketSize):
+		return causeBadPacketSize
+	case errors.Is(err, packet.ErrRepeatedNonce):
+		return causeRepeatedNonce
+	case errors.Is(err, packet.ErrBadTimestamp):
+		return causeBadTimestamp
+	case errors.Is(err, packet.ErrBadMessageType):
+		return causeBadMessageType
+	case errors.Is(err, packet.ErrAuthenticationFailed):
+		return causeAuthenticationFailed
+	case errors.Is(err, ErrClientNotAllowed):
+		return causeClientNotAllowed
+	default:
+		return causeOther
+	}
+}
+
+// serverMetrics holds the counters of a server instance.
+//
+// serverMetrics is safe for concurrent use.
+type serverMetrics struct {
+	requestsHandled  atomic.Uint64
+	rateLimited      atomic.Uint64
+	accessLogDropped atomic.Uint64
+	failures         [failureCauseCount]atomic.Uint64
+
+	mu          sync.Mutex
+	keyRequests map[string]*atomic.Uint64
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		keyRequests: make(map[string]*atomic.Uint64),
+	}
+}
+
+// keyRequestCounter returns the request counter of the named key.
+// Counters are kept across reloads, as long as the key name stays the same.
+func (m *serverMetrics) keyRequestCounter(name string) *atomic.Uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counter, ok := m.keyRequests[name]
+	if !ok {
+		counter = new(atomic.Uint64)
+		m.keyRequests[name] = counter
+	}
+	return counter
+}
+
+// recordFailure counts the failure under its cause.
+func (m *serverMetrics) recordFailure(cause failureCause) {
+	m.failures[cause].Add(1)
+}
+
+// recordHandleError counts an error returned by [Server.handle].
+func (m *serverMetrics) recordHandleError(err error) {
+	if err == ErrRateLimited {
+		m.rateLimited.Add(1)
+		return
+	}
+	m.recordFailure(failureCauseOf(err))
+}
+
+// metricsContentType is the content type of the Prometheus text exposition format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// startMetricsServer starts an HTTP server that serves metrics of the managed servers
+// at /metrics on the given address.
+//
+// The caller must hold m.mu.
+func (m *Manager) startMetricsServer(ctx context.Context, address string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", m.serveMetrics)
+
+	hs, err := startHTTPService(ctx, "tcp", address, mux, m.logger)
+	if err != nil {
+		return err
+	}
+	m.metricsService = hs
+
+	m.logger.Info("Started metrics server", zap.String("listenAddress", address))
+	return nil
+}
+
+// stopMetricsServer stops the metrics server, if it is running.
+//
+// The caller must hold m.mu.
+func (m *Manager) stopMetricsServer() {
+	if m.metricsService == nil {
+		return
+	}
+	if err := m.metricsService.Stop(); err != nil {
+		m.logger.Warn("Failed to stop metrics server", zap.Error(err))
+	}
+	m.metricsService = nil
+	m.logger.Info("Stopped metrics server")
+}
+
+// serveMetrics serves metrics of the managed servers in the Prometheus text exposition format.
+func (m *Manager) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	servers := m.snapshotServers()
+
+	w.Header().Set("Content-Type", metricsContentType)
+	if err := writeMetrics(w, servers); err != nil {
+		m.logger.Debug("Failed to write metrics response", zap.Error(err))
+	}
+}
+
+// writeMetrics writes the metrics of the servers to w in the Prometheus text exposition format.
+func writeMetrics(w io.Writer, servers []*Server) error {
+	var b strings.Builder
+
+	writeHeader := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	writeHeader("opdt_requests_handled_total", "counter", "Number of requests answered with a response.")
+	for _, s := range servers {
+		fmt.Fprintf(&b, "opdt_requests_handled_total{server=\"%s\"} %d\n", escapeLabelValue(s.name), s.metrics.requestsHandled.Load())
+	}
+
+	writeHeader("opdt_request_failures_total", "counter", "Number of packets that did not result in a response, by cause.")
+	for _, s := range servers {
+		for cause := range failureCauseCount {
+			fmt.Fprintf(&b, "opdt_request_failures_total{server=\"%s\",cause=\"%s\"} %d\n", escapeLabelValue(s.name), cause, s.metrics.failures[cause].Load())
+		}
+	}
+
+	writeHeader("opdt_rate_limited_total", "counter", "Number of requests dropped by the rate limiter.")
+	for _, s := range servers {
+		fmt.Fprintf(&b, "opdt_rate_limited_total{server=\"%s\"} %d\n", escapeLabelValue(s.name), s.metrics.rateLimited.Load())
+	}
+
+	writeHeader("opdt_access_log_dropped_total", "counter", "Number of access log entries dropped because the access log could not keep up.")
+	for _, s := range servers {
+		fmt.Fprintf(&b, "opdt_access_log_dropped_total{server=\"%s\"} %d\n", escapeLabelValue(s.name), s.metrics.accessLogDropped.Load())
+	}
+
+	writeHeader("opdt_replay_cache_size", "gauge", "Number of nonces in the replay protection cache.")
+	for _, s := range servers {
+		fmt.Fprintf(&b, "opdt_replay_cache_size{server=\"%s\"} %d\n", escapeLabelValue(s.name), s.replayCacheSize())
+	}
+
+	writeHeader("opdt_key_requests_total", "counter", "Number of authenticated requests, by key.")
+	for _, s := range servers {
+		s.metrics.mu.Lock()
+		keyNames := make([]string, 0, len(s.metrics.keyRequests))
+		for name := range s.metrics.keyRequests {
+			keyNames = append(keyNames, name)
+		}
+		slices.Sort(keyNames)
+		for _, name := range keyNames {
+			fmt.Fprintf(&b, "opdt_key_requests_total{server=\"%s\",key=\"%s\"} %d\n", escapeLabelValue(s.name), escapeLabelValue(name), s.metrics.keyRequests[name].Load())
+		}
+		s.metrics.mu.Unlock()
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// labelValueReplacer escapes label values in the Prometheus text exposition format.
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue escapes a label value in the Prometheus text exposition format.
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
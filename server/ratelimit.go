@@ -0,0 +1,127 @@
+package server
+
+import (
+	"math"
+	"net/netip"
+	"time"
+)
+
+// RateLimitConfig is the configuration of per-client rate limiting.
+type RateLimitConfig struct {
+	// RequestsPerSecond is the sustained number of requests per second allowed from each client IPv4 address,
+	// or IPv6 /64 prefix. If zero, rate limiting is disabled.
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+
+	// Burst is the maximum number of requests a client can make in a burst.
+	// If zero, it defaults to the ceiling of RequestsPerSecond.
+	Burst int `json:"burst"`
+}
+
+// newRateLimiter returns a new rate limiter, or nil if rate limiting is disabled.
+func (c RateLimitConfig) newRateLimiter() *rateLimiter {
+	if c.RequestsPerSecond <= 0 {
+		return nil
+	}
+	burst := float64(c.Burst)
+	if burst <= 0 {
+		burst = math.Ceil(c.RequestsPerSecond)
+	}
+	return &rateLimiter{
+		rate:       c.RequestsPerSecond,
+		burst:      burst,
+		maxBuckets: rateLimiterMaxBuckets,
+		buckets:    make(map[netip.Addr]tokenBucket),
+		overflow:   tokenBucket{tokens: burst},
+		lastClean:  time.Now(),
+	}
+}
+
+const (
+	// rateLimiterCleanInterval is the interval between removals of idle token buckets.
+	rateLimiterCleanInterval = time.Minute
+
+	// rateLimiterMaxBuckets is the maximum number of per-client token buckets.
+	// Buckets are created before authentication, so the number must be bounded.
+	rateLimiterMaxBuckets = 65536
+
+	// rateLimiterIPv6PrefixLen is the length of the prefix IPv6 clients are limited by,
+	// as a single host can usually use any address in its /64.
+	rateLimiterIPv6PrefixLen = 64
+)
+
+// tokenBucket is the state of a client's token bucket.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter implements per-address rate limiting with token buckets.
+// IPv4 clients are limited by address, and IPv6 clients by /64 prefix.
+//
+// When maxBuckets buckets are in use, clients without a bucket share the overflow bucket,
+// until idle buckets are removed.
+//
+// rateLimiter is not safe for concurrent use.
+type rateLimiter struct {
+	rate       float64
+	burst      float64
+	maxBuckets int
+	buckets    map[netip.Addr]tokenBucket
+	overflow   tokenBucket
+	lastClean  time.Time
+}
+
+// rateLimitKey returns the key of the token bucket of the client address.
+func rateLimitKey(addr netip.Addr) netip.Addr {
+	if addr.Is4() {
+		return addr
+	}
+	prefix, _ := addr.Prefix(rateLimiterIPv6PrefixLen)
+	return prefix.Addr()
+}
+
+// Allow returns whether a request from addr at now is allowed, and consumes a token if it is.
+func (l *rateLimiter) Allow(addr netip.Addr, now time.Time) bool {
+	l.clean(now)
+
+	key := rateLimitKey(addr)
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.maxBuckets {
+			return l.overflow.take(now, l.rate, l.burst)
+		}
+		b = tokenBucket{tokens: l.burst, updated: now}
+	}
+
+	allowed := b.take(now, l.rate, l.burst)
+	l.buckets[key] = b
+	return allowed
+}
+
+// take refills the bucket up to now, and consumes a token if one is available.
+// It returns whether a token was consumed.
+func (b *tokenBucket) take(now time.Time, rate, burst float64) bool {
+	if !b.updated.IsZero() {
+		b.tokens = min(burst, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// clean removes token buckets that have refilled completely.
+func (l *rateLimiter) clean(now time.Time) {
+	if now.Sub(l.lastClean) < rateLimiterCleanInterval {
+		return
+	}
+	for addr, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, addr)
+		}
+	}
+	l.lastClean = now
+}
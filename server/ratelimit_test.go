@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterIPv6Prefix(t *testing.T) {
+	l := RateLimitConfig{RequestsPerSecond: 1, Burst: 1}.newRateLimiter()
+	now := time.Now()
+
+	if !l.Allow(netip.MustParseAddr("2001:db8::1"), now) {
+		t.Fatal("First request was not allowed")
+	}
+	if l.Allow(netip.MustParseAddr("2001:db8::ffff:2"), now) {
+		t.Error("Request from the same /64 was allowed, expected it to share the exhausted bucket")
+	}
+	if !l.Allow(netip.MustParseAddr("2001:db8:0:1::1"), now) {
+		t.Error("Request from another /64 was not allowed")
+	}
+	if !l.Allow(netip.MustParseAddr("192.0.2.1"), now) || !l.Allow(netip.MustParseAddr("192.0.2.2"), now) {
+		t.Error("Requests from different IPv4 addresses were not allowed")
+	}
+}
+
+func TestRateLimiterMaxBuckets(t *testing.T) {
+	const maxBuckets = 16
+	l := RateLimitConfig{RequestsPerSecond: 1, Burst: 1}.newRateLimiter()
+	l.maxBuckets = maxBuckets
+	now := time.Now()
+
+	// Each request comes from a new /64, as a spoofing or rotating client would send.
+	addr := netip.MustParseAddr("2001:db8::1")
+	for i := range 1000 {
+		a := addr.As16()
+		a[6], a[7] = byte(i>>8), byte(i)
+		allowed := l.Allow(netip.AddrFrom16(a), now)
+		if expected := i <= maxBuckets; allowed != expected {
+			t.Fatalf("Request %d: got allowed %v, expected %v", i, allowed, expected)
+		}
+	}
+	if n := len(l.buckets); n != maxBuckets {
+		t.Fatalf("Got %d buckets, expected %d", n, maxBuckets)
+	}
+
+	// Clients with a bucket keep it, and idle buckets are removed after the clean interval.
+	if !l.Allow(addr, now.Add(time.Second)) {
+		t.Error("Request from a client with a refilled bucket was not allowed")
+	}
+	if !l.Allow(netip.MustParseAddr("192.0.2.1"), now.Add(rateLimiterCleanInterval)) {
+		t.Error("Request from a new client was not allowed after idle buckets were removed")
+	}
+	if n := len(l.buckets); n != 1 {
+		t.Errorf("Got %d buckets after cleaning, expected 1", n)
+	}
+}
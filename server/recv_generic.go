@@ -0,0 +1,57 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+
+	"github.com/database64128/opdt-go/conn"
+	"github.com/database64128/opdt-go/packet"
+)
+
+func (s *Server) recv() {
+	reqBuf := make([]byte, packet.MaxRequestPacketSize)
+	respBuf := make([]byte, packet.ResponsePacketSize)
+
+	var (
+		n              int
+		flags          int
+		clientAddrPort netip.AddrPort
+		keyName        string
+		err            error
+	)
+
+	for {
+		n, _, flags, clientAddrPort, err = s.serverConn.ReadMsgUDPAddrPort(reqBuf, nil)
+		s.recvProgress.Add(1)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				if s.resumeAfterDeadline() {
+					break
+				}
+				continue
+			}
+
+			s.logReceiveError(clientAddrPort, n, err)
+			continue
+		}
+		if err = conn.ParseFlagsForError(flags); err != nil {
+			s.logReceiveError(clientAddrPort, n, err)
+			continue
+		}
+
+		if keyName, err = s.handle(clientAddrPort, reqBuf[:n], respBuf); err != nil {
+			s.logHandleError(clientAddrPort, n, err)
+			continue
+		}
+
+		if _, err = s.serverConn.WriteToUDPAddrPort(respBuf, clientAddrPort); err != nil {
+			s.logSendError(clientAddrPort, keyName, err)
+			continue
+		}
+
+		s.logHandled(clientAddrPort, keyName)
+	}
+}
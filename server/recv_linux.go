@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"unsafe"
+
+	"github.com/database64128/opdt-go/conn"
+	"github.com/database64128/opdt-go/packet"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// recvBatchSize is the maximum number of packets received or sent in a single recvmmsg or sendmmsg call.
+const recvBatchSize = 64
+
+func (s *Server) recv() {
+	rawConn, err := s.serverConn.SyscallConn()
+	if err != nil {
+		s.logger.Error("Failed to get raw server connection", zap.Error(err))
+		return
+	}
+
+	names := make([]unix.RawSockaddrInet6, recvBatchSize)
+	reqBufs := make([][packet.MaxRequestPacketSize]byte, recvBatchSize)
+	respBufs := make([][packet.ResponsePacketSize]byte, recvBatchSize)
+	reqIovs := make([]unix.Iovec, recvBatchSize)
+	respIovs := make([]unix.Iovec, recvBatchSize)
+	rmsgvec := make([]conn.Mmsghdr, recvBatchSize)
+	smsgvec := make([]conn.Mmsghdr, recvBatchSize)
+	keyNames := make([]string, recvBatchSize)
+
+	for i := range rmsgvec {
+		reqIovs[i].Base = &reqBufs[i][0]
+		reqIovs[i].SetLen(packet.MaxRequestPacketSize)
+		rmsgvec[i].Msghdr.Name = (*byte)(unsafe.Pointer(&names[i]))
+		rmsgvec[i].Msghdr.Iov = &reqIovs[i]
+		rmsgvec[i].Msghdr.SetIovlen(1)
+
+		respIovs[i].Base = &respBufs[i][0]
+		respIovs[i].SetLen(packet.ResponsePacketSize)
+		smsgvec[i].Msghdr.Iov = &respIovs[i]
+		smsgvec[i].Msghdr.SetIovlen(1)
+	}
+
+	var (
+		n     int
+		errno error
+	)
+
+	for {
+		for i := range rmsgvec {
+			rmsgvec[i].Msghdr.Namelen = unix.SizeofSockaddrInet6
+			rmsgvec[i].Msghdr.Flags = 0
+		}
+
+		err = rawConn.Read(func(fd uintptr) bool {
+			n, errno = conn.Recvmmsg(int(fd), rmsgvec, 0)
+			return errno != unix.EAGAIN && errno != unix.EWOULDBLOCK
+		})
+		s.recvProgress.Add(1)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				if s.resumeAfterDeadline() {
+					break
+				}
+				continue
+			}
+
+			s.logReceiveError(netip.AddrPort{}, 0, err)
+			continue
+		}
+		if errno != nil {
+			s.logReceiveError(netip.AddrPort{}, 0, os.NewSyscallError("recvmmsg", errno))
+			continue
+		}
+
+		var count int
+
+		for i := range rmsgvec[:n] {
+			rmsg := &rmsgvec[i]
+			clientAddrPort := conn.AddrPortFromSockaddr(&names[i], rmsg.Msghdr.Namelen)
+			packetLength := int(rmsg.Msglen)
+
+			if err = conn.ParseFlagsForError(int(rmsg.Msghdr.Flags)); err != nil {
+				s.logReceiveError(clientAddrPort, packetLength, err)
+				continue
+			}
+
+			if keyNames[count], err = s.handle(clientAddrPort, reqBufs[i][:packetLength], respBufs[count][:]); err != nil {
+				s.logHandleError(clientAddrPort, packetLength, err)
+				continue
+			}
+
+			smsgvec[count].Msghdr.Name = rmsg.Msghdr.Name
+			smsgvec[count].Msghdr.Namelen = rmsg.Msghdr.Namelen
+			count++
+		}
+
+		for start := 0; start < count; {
+			var written int
+
+			if err = rawConn.Write(func(fd uintptr) bool {
+				written, errno = conn.Sendmmsg(int(fd), smsgvec[start:count], 0)
+				return errno != unix.EAGAIN && errno != unix.EWOULDBLOCK
+			}); err == nil && errno != nil {
+				err = os.NewSyscallError("sendmmsg", errno)
+			}
+			if err != nil {
+				// The first message in the batch failed. Skip it and send the rest.
+				clientAddrPort := conn.AddrPortFromSockaddr((*unix.RawSockaddrInet6)(unsafe.Pointer(smsgvec[start].Msghdr.Name)), smsgvec[start].Msghdr.Namelen)
+				s.logSendError(clientAddrPort, keyNames[start], err)
+				start++
+				continue
+			}
+
+			for i := start; i < start+written; i++ {
+				smsg := &smsgvec[i]
+				clientAddrPort := conn.AddrPortFromSockaddr((*unix.RawSockaddrInet6)(unsafe.Pointer(smsg.Msghdr.Name)), smsg.Msghdr.Namelen)
+				s.logHandled(clientAddrPort, keyNames[i])
+			}
+
+			start += written
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/database64128/opdt-go/packet"
+	"go.uber.org/zap"
+)
+
+// TestRecvBatching checks that a batch of queued requests is received in a single recvmmsg call,
+// and that every request in the batch gets a response.
+func TestRecvBatching(t *testing.T) {
+	psk := newTestPSK()
+	client, err := packet.NewClient(psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ServerConfig{
+		Name:          "test",
+		ListenAddress: "127.0.0.1:0",
+		Keys:          []KeyConfig{{Name: "a", PSK: psk}},
+	}.Server(zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Queue the requests before the receive loop starts, so that they are all available to the first call.
+	if err = s.listen(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	clientConn, err := net.DialUDP("udp", nil, s.serverConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+
+	req := make([]byte, packet.RequestPacketSize)
+	for range recvBatchSize {
+		client.PutRequest(req)
+		if _, err = clientConn.Write(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.serve()
+
+	if err = clientConn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	resp := make([]byte, packet.ResponsePacketSize)
+	for i := range recvBatchSize {
+		n, err := clientConn.Read(resp)
+		if err != nil {
+			t.Fatalf("Response %d: %v", i, err)
+		}
+		if _, err = client.ParseResponse(resp[:n]); err != nil {
+			t.Fatalf("Response %d: %v", i, err)
+		}
+	}
+
+	if calls := s.recvProgress.Load(); calls != 1 {
+		t.Errorf("Got %d receive calls for %d requests, expected 1", calls, recvBatchSize)
+	}
+}
@@ -3,110 +3,449 @@ package server
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"net/netip"
-	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/database64128/opdt-go/accesslog"
 	"github.com/database64128/opdt-go/conn"
 	"github.com/database64128/opdt-go/packet"
+	"github.com/database64128/opdt-go/secret"
+	"github.com/database64128/opdt-go/systemd"
 	"go.uber.org/zap"
 )
 
-type Config struct {
+var (
+	ErrClientNotAllowed = errors.New("client address not allowed")
+	ErrRateLimited      = errors.New("rate limited")
+	ErrAllKeysRevoked   = errors.New("all keys revoked")
+)
+
+// KeyConfig is the configuration of a pre-shared key.
+type KeyConfig struct {
+	// Name identifies the key in logs.
+	// If empty, the key's index in the list is used.
+	Name string `json:"name"`
+
+	// PSK is the 32-byte pre-shared key.
+	PSK secret.Key `json:"psk,omitempty"`
+
+	// PSKFile is the path to a file containing the base64-encoded pre-shared key, used instead of PSK.
+	// A bare file name is looked up in $CREDENTIALS_DIRECTORY if set.
+	// The file must not be writable by its group, or accessible by others.
+	PSKFile string `json:"pskFile,omitempty"`
+
+	// Argon2id derives the pre-shared key from a passphrase, used instead of PSK.
+	Argon2id *secret.Argon2idConfig `json:"argon2id,omitempty"`
+}
+
+// Key returns the configured pre-shared key.
+func (kc *KeyConfig) Key() (secret.Key, error) {
+	return secret.LoadKey(kc.PSK, kc.PSKFile, kc.Argon2id)
+}
+
+// SystemdListenPrefix is the prefix of a listen address that refers to a socket
+// passed by systemd socket activation, by its file descriptor name.
+const SystemdListenPrefix = "systemd:"
+
+// ServerConfig is the configuration of a server instance.
+type ServerConfig struct {
+	// Name identifies the server instance in logs.
+	// If empty, the listen address is used.
+	Name string `json:"name"`
+
+	// ListenAddress is the UDP address to listen on.
+	//
+	// If it has the prefix "systemd:", the server takes over the socket
+	// passed by systemd socket activation with the file descriptor name after the prefix,
+	// as set by FileDescriptorName= in the socket unit.
 	ListenAddress string `json:"listen"`
-	PSK           []byte `json:"psk"`
+
+	// Keys is the list of pre-shared keys accepted by the server, at most [packet.MaxKeys].
+	//
+	// Requests carry no key identifier, and are decrypted with each key in turn.
+	// A request that fails authentication costs one decryption attempt per key,
+	// and the rate limit only applies per client address,
+	// so a flood from many addresses costs that much for every packet.
+	// Serve large groups of keys on separate listeners.
+	Keys []KeyConfig `json:"keys"`
+
+	// AllowedClients is the list of client address prefixes allowed to query the server.
+	// If empty, all clients are allowed.
+	AllowedClients []netip.Prefix `json:"allowedClients,omitempty"`
+
+	// RateLimit limits the rate of requests from each client IP address.
+	RateLimit RateLimitConfig `json:"rateLimit"`
+}
+
+// name returns the configured name, or the listen address if the name is empty.
+func (sc *ServerConfig) name() string {
+	if sc.Name != "" {
+		return sc.Name
+	}
+	return sc.ListenAddress
+}
+
+// keyName returns the name of the i-th key, or its index if the name is empty.
+func (sc *ServerConfig) keyName(i int) string {
+	if name := sc.Keys[i].Name; name != "" {
+		return name
+	}
+	return strconv.Itoa(i)
+}
+
+// keyNames returns the names of all configured keys.
+func (sc *ServerConfig) keyNames() []string {
+	names := make([]string, len(sc.Keys))
+	for i := range sc.Keys {
+		names[i] = sc.keyName(i)
+	}
+	return names
+}
+
+// Server creates a new server from the configuration.
+func (sc ServerConfig) Server(logger *zap.Logger) (*Server, error) {
+	metrics := newServerMetrics()
+	policy, err := sc.policy(nil, metrics, nil)
+	if err != nil {
+		return nil, err
+	}
+	return newServer(sc.name(), sc.ListenAddress, policy, metrics, logger), nil
 }
 
-func (c Config) Server(logger *zap.Logger) (*Server, error) {
-	handler, err := packet.NewServer(c.PSK)
+// policy builds the server policy from the configuration.
+//
+// If prev is not nil, the new policy shares the replay protection state of prev,
+// and keeps the rate limiter state if the rate limit is unchanged.
+// Per-key request counters are looked up from metrics by key name.
+// Keys whose names are in revoked are left out.
+func (sc *ServerConfig) policy(prev *serverPolicy, metrics *serverMetrics, revoked map[string]struct{}) (*serverPolicy, error) {
+	if len(sc.Keys) == 0 {
+		return nil, packet.ErrNoKeys
+	}
+
+	psks := make([][]byte, 0, len(sc.Keys))
+	keyNames := make([]string, 0, len(sc.Keys))
+	keyRequests := make([]*atomic.Uint64, 0, len(sc.Keys))
+	for i, key := range sc.Keys {
+		name := sc.keyName(i)
+		if _, ok := revoked[name]; ok {
+			continue
+		}
+		psk, err := key.Key()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", name, err)
+		}
+		psks = append(psks, psk)
+		keyNames = append(keyNames, name)
+		keyRequests = append(keyRequests, metrics.keyRequestCounter(name))
+	}
+	if len(psks) == 0 {
+		return nil, ErrAllKeysRevoked
+	}
+
+	var (
+		handler *packet.Server
+		limiter *rateLimiter
+		err     error
+	)
+
+	if prev != nil {
+		handler, err = prev.handler.WithKeys(psks...)
+	} else {
+		handler, err = packet.NewServer(psks...)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &Server{
-		listenAddress: c.ListenAddress,
-		handler:       handler,
-		logger:        logger,
+
+	if prev != nil && prev.rateLimit == sc.RateLimit {
+		limiter = prev.limiter
+	} else {
+		limiter = sc.RateLimit.newRateLimiter()
+	}
+
+	return &serverPolicy{
+		handler:        handler,
+		keyNames:       keyNames,
+		keyRequests:    keyRequests,
+		allowedClients: sc.AllowedClients,
+		rateLimit:      sc.RateLimit,
+		limiter:        limiter,
 	}, nil
 }
 
+// serverPolicy holds the parts of a server's configuration that can be swapped at runtime.
+type serverPolicy struct {
+	handler        *packet.Server
+	keyNames       []string
+	keyRequests    []*atomic.Uint64
+	allowedClients []netip.Prefix
+	rateLimit      RateLimitConfig
+	limiter        *rateLimiter
+}
+
+// isClientAllowed returns whether the client address is allowed by the access control list.
+func (p *serverPolicy) isClientAllowed(clientAddr netip.Addr) bool {
+	if len(p.allowedClients) == 0 {
+		return true
+	}
+	for _, prefix := range p.allowedClients {
+		if prefix.Contains(clientAddr) {
+			return true
+		}
+	}
+	return false
+}
+
 type Server struct {
+	name          string
 	listenAddress string
 	serverConn    *net.UDPConn
-	handler       *packet.Server
+	policy        atomic.Pointer[serverPolicy]
+	accessLog     atomic.Pointer[accesslog.Logger]
+	metrics       *serverMetrics
+	events        *eventBroker
+	recentErrors  *errorRing
+	inventory     *clientInventory
 	logger        *zap.Logger
 	wg            sync.WaitGroup
+
+	// recvProgress counts the returns from the receive call in the receive loop.
+	recvProgress atomic.Uint64
+
+	// polledProgress is recvProgress at the previous poll by the manager, if polled is true.
+	// Both are protected by the manager's mutex.
+	polledProgress uint64
+	polled         bool
+
+	// stopping is set by [Server.Stop] before it interrupts the receive loop.
+	stopping atomic.Bool
+}
+
+func newServer(name, listenAddress string, policy *serverPolicy, metrics *serverMetrics, logger *zap.Logger) *Server {
+	s := &Server{
+		name:          name,
+		listenAddress: listenAddress,
+		metrics:       metrics,
+		logger:        logger.With(zap.String("server", name)),
+	}
+	s.policy.Store(policy)
+	return s
 }
 
 func (s *Server) Start(ctx context.Context) error {
+	if err := s.listen(ctx); err != nil {
+		return err
+	}
+	s.serve()
+	return nil
+}
+
+// listen opens the server socket, or takes over the socket passed by systemd.
+func (s *Server) listen(ctx context.Context) error {
+	if name, ok := strings.CutPrefix(s.listenAddress, SystemdListenPrefix); ok {
+		serverConn, err := systemd.ListenUDP(name)
+		if err != nil {
+			return err
+		}
+		s.serverConn = serverConn
+		return nil
+	}
+
 	var lc net.ListenConfig
 	serverConn, err := lc.ListenPacket(ctx, "udp", s.listenAddress)
 	if err != nil {
 		return err
 	}
 	s.serverConn = serverConn.(*net.UDPConn)
+	return nil
+}
 
+// serve starts the receive loop on the opened server socket.
+func (s *Server) serve() {
 	s.wg.Go(func() {
 		s.recv()
 	})
+}
 
-	return nil
+// wake interrupts the blocking receive call of the receive loop,
+// so that the loop makes progress even when no packets arrive.
+func (s *Server) wake() {
+	if s.serverConn == nil {
+		return
+	}
+	if err := s.serverConn.SetReadDeadline(conn.ALongTimeAgo); err != nil {
+		s.logger.Warn("Failed to set read deadline on server connection", zap.Error(err))
+	}
 }
 
-func (s *Server) recv() {
-	reqBuf := make([]byte, packet.RequestPacketSize)
-	respBuf := make([]byte, packet.ResponsePacketSize)
+// resumeAfterDeadline is called by the receive loop when the read deadline is exceeded.
+// It returns true if the server is stopping. Otherwise the deadline was set by [Server.wake],
+// and is cleared so that the loop can continue.
+func (s *Server) resumeAfterDeadline() (stop bool) {
+	if s.stopping.Load() {
+		return true
+	}
+	if err := s.serverConn.SetReadDeadline(time.Time{}); err != nil {
+		s.logger.Warn("Failed to clear read deadline on server connection", zap.Error(err))
+	}
+	// Stop may have set its deadline before it was cleared above.
+	return s.stopping.Load()
+}
 
-	var (
-		n              int
-		flags          int
-		clientAddrPort netip.AddrPort
-		err            error
-	)
+// handle checks the request against the server's access control list and rate limit,
+// then processes the request and writes the response to resp.
+// Authenticated requests are recorded in the client inventory, if attached.
+// It returns the name of the key that authenticated the request.
+func (s *Server) handle(clientAddrPort netip.AddrPort, req, resp []byte) (string, error) {
+	policy := s.policy.Load()
+	clientAddr := clientAddrPort.Addr().Unmap()
 
-	for {
-		n, _, flags, clientAddrPort, err = s.serverConn.ReadMsgUDPAddrPort(reqBuf, nil)
-		if err != nil {
-			if errors.Is(err, os.ErrDeadlineExceeded) {
-				break
-			}
-
-			s.logger.Warn("Failed to receive packet",
-				zap.Stringer("clientAddress", &clientAddrPort),
-				zap.Int("packetLength", n),
-				zap.Error(err),
-			)
-			continue
-		}
-		if err = conn.ParseFlagsForError(flags); err != nil {
-			s.logger.Warn("Failed to receive packet",
-				zap.Stringer("clientAddress", &clientAddrPort),
-				zap.Int("packetLength", n),
-				zap.Error(err),
-			)
-			continue
-		}
+	if !policy.isClientAllowed(clientAddr) {
+		return "", ErrClientNotAllowed
+	}
 
-		if err = s.handler.Handle(clientAddrPort, reqBuf[:n], respBuf); err != nil {
-			s.logger.Warn("Failed to handle request",
-				zap.Stringer("clientAddress", &clientAddrPort),
-				zap.Int("packetLength", n),
-				zap.Error(err),
-			)
-			continue
-		}
+	if policy.limiter != nil && !policy.limiter.Allow(clientAddr, time.Now()) {
+		return "", ErrRateLimited
+	}
 
-		if _, err = s.serverConn.WriteToUDPAddrPort(respBuf, clientAddrPort); err != nil {
-			s.logger.Warn("Failed to send response",
-				zap.Stringer("clientAddress", &clientAddrPort),
-				zap.Int("packetLength", n),
-				zap.Error(err),
-			)
-			continue
+	keyIndex, localAddrPort, err := policy.handler.Handle(clientAddrPort, req, resp)
+	if err != nil {
+		return "", err
+	}
+	policy.keyRequests[keyIndex].Add(1)
+	keyName := policy.keyNames[keyIndex]
+
+	if s.inventory != nil {
+		s.inventory.Observe(s.name, keyName, netip.AddrPortFrom(clientAddr, clientAddrPort.Port()), localAddrPort, time.Now())
+	}
+
+	return keyName, nil
+}
+
+// replayCacheSize returns the number of nonces in the replay protection cache.
+// It is read when metrics are scraped, so that the receive loop does not have to publish it.
+func (s *Server) replayCacheSize() int64 {
+	return int64(s.policy.Load().handler.ReplayCacheSize())
+}
+
+// recordOutcome writes the outcome of a request to the access log, if enabled,
+// and publishes it to event subscribers, if any.
+func (s *Server) recordOutcome(clientAddrPort netip.AddrPort, keyName, outcome string) {
+	l := s.accessLog.Load()
+	hasSubscribers := s.events != nil && s.events.HasSubscribers()
+	if l == nil && !hasSubscribers {
+		return
+	}
+
+	entry := accesslog.Entry{
+		Time:          time.Now(),
+		Server:        s.name,
+		ClientAddress: netip.AddrPortFrom(clientAddrPort.Addr().Unmap(), clientAddrPort.Port()),
+		Key:           keyName,
+		Outcome:       outcome,
+	}
+
+	// Packets that were not authenticated can be sent by anyone, so they are only logged on request.
+	if l != nil && (keyName != "" || l.Config().LogUnauthenticated) {
+		if !l.Log(entry) {
+			s.metrics.accessLogDropped.Add(1)
 		}
+	}
+
+	if hasSubscribers {
+		s.events.Publish(entry)
+	}
+}
+
+// recordError keeps the error for introspection, if enabled.
+func (s *Server) recordError(clientAddrPort netip.AddrPort, cause string, err error) {
+	if s.recentErrors == nil {
+		return
+	}
+	s.recentErrors.Add(recentError{
+		Time:          time.Now(),
+		Server:        s.name,
+		ClientAddress: clientAddrPort,
+		Cause:         cause,
+		Error:         err.Error(),
+	})
+}
+
+// logReceiveError records and logs a failure to receive a packet.
+func (s *Server) logReceiveError(clientAddrPort netip.AddrPort, packetLength int, err error) {
+	cause := failureCauseOf(err)
+	if cause == causeOther {
+		cause = causeReceiveError
+	}
+	s.metrics.recordFailure(cause)
+	s.recordError(clientAddrPort, cause.String(), err)
+	if clientAddrPort.IsValid() {
+		s.recordOutcome(clientAddrPort, "", cause.String())
+	}
+	s.logger.Warn("Failed to receive packet",
+		zap.Stringer("clientAddress", &clientAddrPort),
+		zap.Int("packetLength", packetLength),
+		zap.Error(err),
+	)
+}
+
+// logSendError records and logs a failure to send a response.
+func (s *Server) logSendError(clientAddrPort netip.AddrPort, keyName string, err error) {
+	s.metrics.recordFailure(causeSendError)
+	s.recordError(clientAddrPort, causeSendError.String(), err)
+	s.recordOutcome(clientAddrPort, keyName, causeSendError.String())
+	s.logger.Warn("Failed to send response",
+		zap.Stringer("clientAddress", &clientAddrPort),
+		zap.Error(err),
+	)
+}
+
+// logHandled records and logs a handled request.
+//
+// When the access log is enabled, the operational log entry is demoted to debug level.
+func (s *Server) logHandled(clientAddrPort netip.AddrPort, keyName string) {
+	s.metrics.requestsHandled.Add(1)
+	s.recordOutcome(clientAddrPort, keyName, "handled")
 
-		s.logger.Info("Handled request", zap.Stringer("clientAddress", &clientAddrPort))
+	level := zap.InfoLevel
+	if s.accessLog.Load() != nil {
+		level = zap.DebugLevel
 	}
+	s.logger.Log(level, "Handled request",
+		zap.Stringer("clientAddress", &clientAddrPort),
+		zap.String("key", keyName),
+	)
+}
+
+// logHandleError records and logs a failure returned by handle.
+//
+// Rate-limited requests are not kept as recent errors, and are logged at debug level
+// to avoid flooding the log.
+func (s *Server) logHandleError(clientAddrPort netip.AddrPort, packetLength int, err error) {
+	s.metrics.recordHandleError(err)
+
+	level := zap.WarnLevel
+	if err == ErrRateLimited {
+		s.recordOutcome(clientAddrPort, "", "rate_limited")
+		level = zap.DebugLevel
+	} else {
+		cause := failureCauseOf(err).String()
+		s.recordError(clientAddrPort, cause, err)
+		s.recordOutcome(clientAddrPort, "", cause)
+	}
+
+	s.logger.Log(level, "Failed to handle request",
+		zap.Stringer("clientAddress", &clientAddrPort),
+		zap.Int("packetLength", packetLength),
+		zap.Error(err),
+	)
 }
 
 func (s *Server) Stop() error {
@@ -114,6 +453,8 @@ func (s *Server) Stop() error {
 		return nil
 	}
 
+	s.stopping.Store(true)
+
 	if err := s.serverConn.SetReadDeadline(conn.ALongTimeAgo); err != nil {
 		s.logger.Error("Failed to set read deadline on server connection", zap.Error(err))
 	}
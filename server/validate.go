@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/database64128/opdt-go/packet"
+	"github.com/database64128/opdt-go/secret"
+	"github.com/database64128/opdt-go/validate"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Validate checks the configuration without opening any sockets, and returns all problems found.
+// Keys are loaded from their files and derived from their passphrases.
+func (c *Config) Validate() validate.Problems {
+	var ps validate.Problems
+
+	if c.ListenAddress != "" {
+		ps.Addf("listen", "deprecated top-level setting, move it into a server instance in servers")
+	}
+	if len(c.PSK) > 0 {
+		ps.Addf("psk", "deprecated top-level setting, move it into the keys of a server instance in servers")
+	}
+
+	if len(c.Servers) == 0 {
+		ps.Addf("servers", "no servers")
+	}
+
+	names := make(map[string]int, len(c.Servers))
+	sockets := make(map[string]int)
+	listeners := make([]listener, 0, len(c.Servers))
+
+	for i := range c.Servers {
+		sc := &c.Servers[i]
+		path := validate.Index("servers", i)
+
+		name := sc.name()
+		if j, ok := names[name]; ok {
+			ps.Addf(validate.Field(path, "name"), "duplicate server name %q, also used by %s", name, validate.Index("servers", j))
+		} else {
+			names[name] = i
+		}
+
+		if socketName, ok := strings.CutPrefix(sc.ListenAddress, SystemdListenPrefix); ok {
+			if socketName == "" {
+				ps.Addf(validate.Field(path, "listen"), "no systemd socket name")
+			} else if j, ok := sockets[socketName]; ok {
+				ps.Addf(validate.Field(path, "listen"), "systemd socket %q is also used by %s", socketName, validate.Index("servers", j))
+			} else {
+				sockets[socketName] = i
+			}
+			listeners = append(listeners, listener{})
+			sc.validateKeys(path, &ps)
+			sc.validateRateLimit(path, &ps)
+			continue
+		}
+
+		l, err := parseListener(sc.ListenAddress)
+		switch {
+		case err != nil:
+			ps.Add(validate.Field(path, "listen"), err)
+		case !l.addr.IsValid() && !l.wildcard:
+			ps.Warnf(validate.Field(path, "listen"), "host name is resolved at start, so conflicts with other servers cannot be checked")
+		default:
+			for j, other := range listeners {
+				if l.conflicts(other) {
+					ps.Addf(validate.Field(path, "listen"), "%s conflicts with %q of %s", sc.ListenAddress, c.Servers[j].ListenAddress, validate.Index("servers", j))
+				}
+			}
+		}
+		listeners = append(listeners, l)
+
+		sc.validateKeys(path, &ps)
+		sc.validateRateLimit(path, &ps)
+	}
+
+	if c.MetricsListenAddress != "" {
+		host, _, err := net.SplitHostPort(c.MetricsListenAddress)
+		if err != nil {
+			ps.Add("metricsListen", err)
+		} else if addr, err := netip.ParseAddr(host); err != nil || !addr.IsLoopback() {
+			ps.Warnf("metricsListen", "metrics are served to the network, not only to the local host")
+		}
+	}
+
+	if c.AccessLog != nil && c.AccessLog.Path == "" {
+		ps.Addf("accessLog.path", "no path")
+	}
+
+	if c.Inventory.MaxHistory < 0 {
+		ps.Addf("inventory.maxHistory", "negative history size %d", c.Inventory.MaxHistory)
+	}
+
+	return ps
+}
+
+// validateRateLimit checks the rate limit and access control of the server at path.
+func (sc *ServerConfig) validateRateLimit(path string, ps *validate.Problems) {
+	if sc.RateLimit.RequestsPerSecond < 0 {
+		ps.Addf(validate.Field(path, "rateLimit.requestsPerSecond"), "negative rate %v", sc.RateLimit.RequestsPerSecond)
+	}
+	if sc.RateLimit.Burst < 0 {
+		ps.Addf(validate.Field(path, "rateLimit.burst"), "negative burst %d", sc.RateLimit.Burst)
+	}
+	if sc.RateLimit.RequestsPerSecond == 0 && len(sc.AllowedClients) == 0 {
+		ps.Warnf(path, "neither rate limit nor allowed clients configured, so any client may send requests at any rate")
+	}
+}
+
+// validateKeys checks the keys of the server at path.
+func (sc *ServerConfig) validateKeys(path string, ps *validate.Problems) {
+	keysPath := validate.Field(path, "keys")
+	if len(sc.Keys) == 0 {
+		ps.Addf(keysPath, "no keys")
+		return
+	}
+	if len(sc.Keys) > packet.MaxKeys {
+		ps.Addf(keysPath, "%d keys, at most %d allowed, as each request is tried against every key", len(sc.Keys), packet.MaxKeys)
+	}
+
+	names := make(map[string]int, len(sc.Keys))
+	keys := make([]secret.Key, len(sc.Keys))
+
+	for i := range sc.Keys {
+		kc := &sc.Keys[i]
+		keyPath := validate.Index(keysPath, i)
+
+		name := sc.keyName(i)
+		if j, ok := names[name]; ok {
+			ps.Addf(validate.Field(keyPath, "name"), "duplicate key name %q, also used by %s", name, validate.Index(keysPath, j))
+		} else {
+			names[name] = i
+		}
+
+		sourcePath := validate.Field(keyPath, kc.source())
+
+		if kc.Argon2id != nil {
+			for _, w := range kc.Argon2id.Warnings() {
+				ps.Warnf(sourcePath, "%s", w)
+			}
+		}
+
+		key, err := kc.Key()
+		if err != nil {
+			ps.Add(sourcePath, err)
+			continue
+		}
+		if _, err = chacha20poly1305.NewX(key); err != nil {
+			ps.Addf(sourcePath, "key is %d bytes, expected %d: %w", len(key), chacha20poly1305.KeySize, err)
+			continue
+		}
+
+		for j := range i {
+			if bytes.Equal(keys[j], key) {
+				ps.Warnf(sourcePath, "same key as %s, so requests cannot be attributed to either name", validate.Index(keysPath, j))
+				break
+			}
+		}
+		keys[i] = key
+	}
+}
+
+// source returns the name of the field the key is configured with.
+func (kc *KeyConfig) source() string {
+	switch {
+	case kc.PSKFile != "":
+		return "pskFile"
+	case kc.Argon2id != nil:
+		return "argon2id"
+	default:
+		return "psk"
+	}
+}
+
+// listener is a parsed listen address, for checking conflicts between listeners.
+type listener struct {
+	// addr is the IP address, or the zero value for a host name or an empty host.
+	addr netip.Addr
+
+	// port is the port. Port 0 never conflicts.
+	port uint16
+
+	// wildcard is true if the address is empty or unspecified.
+	wildcard bool
+}
+
+// parseListener parses the listen address in the form "host:port".
+func parseListener(address string) (listener, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return listener{}, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return listener{}, err
+	}
+
+	l := listener{port: uint16(port)}
+	if host == "" {
+		l.wildcard = true
+		return l, nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		l.addr = addr.Unmap()
+		l.wildcard = addr.IsUnspecified()
+	}
+	return l, nil
+}
+
+// conflicts returns whether binding both listeners on the same host fails.
+//
+// An empty host, or the IPv6 unspecified address, binds a dual-stack socket
+// that conflicts with all addresses of both families on the same port.
+// The IPv4 unspecified address conflicts with all IPv4 addresses on the same port.
+func (l listener) conflicts(other listener) bool {
+	if l.port == 0 || l.port != other.port {
+		return false
+	}
+	if !l.addr.IsValid() && !l.wildcard || !other.addr.IsValid() && !other.wildcard {
+		return false
+	}
+	if l.dualStack() || other.dualStack() {
+		return true
+	}
+	if l.addr.Is4() != other.addr.Is4() {
+		return false
+	}
+	return l.wildcard || other.wildcard || l.addr == other.addr
+}
+
+// dualStack returns whether the listener binds a dual-stack wildcard socket.
+func (l listener) dualStack() bool {
+	return l.wildcard && !l.addr.Is4()
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"encoding/json"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/database64128/opdt-go/packet"
+	"github.com/database64128/opdt-go/validate"
+)
+
+func TestConfigValidate(t *testing.T) {
+	psk := make([]byte, 32)
+	otherPSK := make([]byte, 32)
+	otherPSK[0] = 1
+	allowed := []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+
+	tooManyKeys := make([]KeyConfig, packet.MaxKeys+1)
+	for i := range tooManyKeys {
+		tooManyKeys[i].PSK = make([]byte, 32)
+		tooManyKeys[i].PSK[1] = byte(i)
+	}
+
+	c := Config{
+		Servers: []ServerConfig{
+			{
+				Name:           "v4",
+				ListenAddress:  "0.0.0.0:30720",
+				Keys:           []KeyConfig{{Name: "a", PSK: psk}, {Name: "b", PSK: otherPSK}},
+				AllowedClients: allowed,
+			},
+			{
+				Name:           "v6",
+				ListenAddress:  "[::]:30720",
+				Keys:           []KeyConfig{{Name: "a", PSK: psk[:16]}, {Name: "a", PSK: otherPSK}},
+				AllowedClients: allowed,
+			},
+			{
+				Name:           "v4",
+				ListenAddress:  "127.0.0.1",
+				Keys:           []KeyConfig{{Name: "a", PSK: psk}, {Name: "b", PSK: psk}},
+				AllowedClients: allowed,
+			},
+			{
+				Name:          "loopback",
+				ListenAddress: "[::1]:30721",
+				Keys:          []KeyConfig{{PSK: psk}},
+			},
+			{
+				Name:           "activated",
+				ListenAddress:  "systemd:opdt-go",
+				Keys:           []KeyConfig{{PSK: psk}},
+				AllowedClients: allowed,
+			},
+			{
+				Name:           "activated-again",
+				ListenAddress:  "systemd:opdt-go",
+				Keys:           []KeyConfig{{PSK: psk}},
+				AllowedClients: allowed,
+			},
+			{
+				Name:           "crowded",
+				ListenAddress:  "[::1]:30722",
+				Keys:           tooManyKeys,
+				AllowedClients: allowed,
+			},
+		},
+		MetricsListenAddress: "127.0.0.1:9720",
+	}
+
+	var errorPaths, warningPaths []string
+	for _, p := range c.Validate() {
+		if p.Warning {
+			warningPaths = append(warningPaths, p.Path)
+		} else {
+			errorPaths = append(errorPaths, p.Path)
+		}
+	}
+
+	expectedErrorPaths := []string{
+		"servers[1].listen",
+		"servers[1].keys[0].psk",
+		"servers[1].keys[1].name",
+		"servers[2].name",
+		"servers[2].listen",
+		"servers[5].listen",
+		"servers[6].keys",
+	}
+	expectedWarningPaths := []string{
+		"servers[2].keys[1].psk",
+		"servers[3]",
+	}
+
+	if !slices.Equal(errorPaths, expectedErrorPaths) {
+		t.Errorf("Got errors at %q, expected %q", errorPaths, expectedErrorPaths)
+	}
+	if !slices.Equal(warningPaths, expectedWarningPaths) {
+		t.Errorf("Got warnings at %q, expected %q", warningPaths, expectedWarningPaths)
+	}
+}
+
+func TestConfigDecodeError(t *testing.T) {
+	for _, c := range []struct {
+		doc  string
+		path string
+	}{
+		{`{"servers":[{"listen":":30720","keys":[]},{"listen":":30721","rateLimit":{"burst":"5"}}]}`, "servers[1].rateLimit.burst"},
+		{`{"servers":[{"listen":":30720","keys":[{"name":"a","psk":"not base64"}]}]}`, "servers[0].keys[0].psk"},
+		{`{"servers":[{"listen":":30720","keys":[{"name":"a","argon2id":{"passphrase":"p","saltt":""}}]}]}`, "servers[0].keys[0].argon2id.saltt"},
+		{`{"inventory":{"maxHistory":1.5}}`, "inventory.maxHistory"},
+	} {
+		var sc Config
+		dec := json.NewDecoder(strings.NewReader(c.doc))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&sc)
+		if err == nil {
+			t.Fatalf("Decoding %s succeeded, expected an error", c.doc)
+		}
+		if p := validate.DecodeError([]byte(c.doc), &sc, err); p.Path != c.path {
+			t.Errorf("Got error %v at %q, expected path %q", p.Err, p.Path, c.path)
+		}
+	}
+}
+
+func TestConfigUpgradeLegacy(t *testing.T) {
+	const psk = "XbQZKDJTbbhuSwF0muQx6L9swsAmf0VOYIApri7nHUQ="
+
+	for _, c := range []struct {
+		doc      string
+		upgraded bool
+		errPaths []string
+	}{
+		{`{"listen":"127.0.0.1:30720","psk":"` + psk + `"}`, true, nil},
+		{`{"listen":"127.0.0.1:30720","psk":"` + psk + `","servers":[{"listen":"127.0.0.1:30721","keys":[{"psk":"` + psk + `"}]}]}`, false, []string{"listen", "psk"}},
+		{`{"servers":[{"listen":"127.0.0.1:30721","keys":[{"psk":"` + psk + `"}]}]}`, false, nil},
+	} {
+		var sc Config
+		dec := json.NewDecoder(strings.NewReader(c.doc))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&sc); err != nil {
+			t.Fatalf("Failed to decode %s: %v", c.doc, err)
+		}
+
+		if upgraded := sc.UpgradeLegacy(); upgraded != c.upgraded {
+			t.Errorf("UpgradeLegacy() on %s returned %v, expected %v", c.doc, upgraded, c.upgraded)
+		}
+		if c.upgraded {
+			if len(sc.Servers) != 1 || sc.Servers[0].ListenAddress != "127.0.0.1:30720" || len(sc.Servers[0].Keys) != 1 {
+				t.Errorf("Got servers %+v, expected a single server with a single key", sc.Servers)
+			}
+		}
+
+		var errPaths []string
+		for _, p := range sc.Validate() {
+			if !p.Warning {
+				errPaths = append(errPaths, p.Path)
+			}
+		}
+		if !slices.Equal(errPaths, c.errPaths) {
+			t.Errorf("Got errors at %v for %s, expected %v", errPaths, c.doc, c.errPaths)
+		}
+	}
+}
+
+func TestListenerConflicts(t *testing.T) {
+	for _, c := range []struct {
+		a, b     string
+		conflict bool
+	}{
+		{":30720", "127.0.0.1:30720", true},
+		{"[::]:30720", "0.0.0.0:30720", true},
+		{"0.0.0.0:30720", "[::1]:30720", false},
+		{"0.0.0.0:30720", "127.0.0.1:30720", true},
+		{"127.0.0.1:30720", "127.0.0.2:30720", false},
+		{"[::1]:30720", "[::1]:30720", true},
+		{"[::1]:30720", "[::1]:30721", false},
+		{":0", ":0", false},
+		{"localhost:30720", "127.0.0.1:30720", false},
+	} {
+		a, err := parseListener(c.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := parseListener(c.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.conflicts(b); got != c.conflict {
+			t.Errorf("Got conflict %v between %s and %s, expected %v", got, c.a, c.b, c.conflict)
+		}
+		if got := b.conflicts(a); got != c.conflict {
+			t.Errorf("Got conflict %v between %s and %s, expected %v", got, c.b, c.a, c.conflict)
+		}
+	}
+}
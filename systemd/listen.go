@@ -0,0 +1,115 @@
+// Package systemd implements the systemd socket activation and service notification protocols.
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Environment variables set by systemd for socket activation.
+const (
+	ListenPIDEnv     = "LISTEN_PID"
+	ListenFDsEnv     = "LISTEN_FDS"
+	ListenFDNamesEnv = "LISTEN_FDNAMES"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+var (
+	ErrSocketNotFound  = errors.New("no socket passed by systemd with this name")
+	ErrAmbiguousSocket = errors.New("more than one socket passed by systemd with this name")
+	ErrNotUDPSocket    = errors.New("socket passed by systemd is not a UDP socket")
+)
+
+var (
+	listenOnce  sync.Once
+	listenFiles []*os.File
+	listenErr   error
+)
+
+// ListenFiles returns the sockets passed by systemd socket activation.
+// The name of each file is its name in $LISTEN_FDNAMES, or "unknown" if not named.
+//
+// The environment variables are read and unset on the first call,
+// so that child processes do not inherit them.
+// The returned files stay open for the lifetime of the process,
+// so that a socket can be taken over again after its connection is closed.
+func ListenFiles() ([]*os.File, error) {
+	listenOnce.Do(func() {
+		listenFiles, listenErr = parseListenEnv(os.Getenv(ListenPIDEnv), os.Getenv(ListenFDsEnv), os.Getenv(ListenFDNamesEnv))
+		os.Unsetenv(ListenPIDEnv)
+		os.Unsetenv(ListenFDsEnv)
+		os.Unsetenv(ListenFDNamesEnv)
+	})
+	return listenFiles, listenErr
+}
+
+// parseListenEnv returns the files described by the socket activation environment variables.
+// The files are only returned if pid is the current process ID.
+func parseListenEnv(pid, fds, fdNames string) ([]*os.File, error) {
+	if pid == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("bad %s %q", ListenFDsEnv, fds)
+	}
+
+	names := strings.Split(fdNames, ":")
+	if len(names) != n {
+		names = nil
+	}
+
+	files := make([]*os.File, n)
+	for i := range n {
+		fd := listenFDsStart + i
+		closeOnExec(fd)
+		name := "unknown"
+		if names != nil {
+			name = names[i]
+		}
+		files[i] = os.NewFile(uintptr(fd), name)
+	}
+	return files, nil
+}
+
+// ListenUDP returns a new UDP connection on the socket passed by systemd with the given name.
+// The connection uses a duplicate of the file descriptor, so closing it leaves the socket open.
+func ListenUDP(name string) (*net.UDPConn, error) {
+	files, err := ListenFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var file *os.File
+	for _, f := range files {
+		if f.Name() != name {
+			continue
+		}
+		if file != nil {
+			return nil, fmt.Errorf("%w: %q", ErrAmbiguousSocket, name)
+		}
+		file = f
+	}
+	if file == nil {
+		return nil, fmt.Errorf("%w: %q", ErrSocketNotFound, name)
+	}
+
+	c, err := net.FilePacketConn(file)
+	if err != nil {
+		return nil, fmt.Errorf("socket %q: %w", name, err)
+	}
+	udpConn, ok := c.(*net.UDPConn)
+	if !ok {
+		c.Close()
+		return nil, fmt.Errorf("%w: %q", ErrNotUDPSocket, name)
+	}
+	return udpConn, nil
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package systemd
+
+// closeOnExec is a no-op on platforms without socket activation.
+func closeOnExec(fd int) {}
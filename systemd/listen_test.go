@@ -0,0 +1,31 @@
+package systemd
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestParseListenEnv(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	for _, c := range []struct {
+		pid, fds, fdNames string
+		ok                bool
+	}{
+		{"", "", "", true},
+		{"1", "2", "a:b", true},
+		{pid, "0", "", true},
+		{pid, "", "", false},
+		{pid, "-1", "", false},
+		{pid, "x", "", false},
+	} {
+		files, err := parseListenEnv(c.pid, c.fds, c.fdNames)
+		if (err == nil) != c.ok {
+			t.Errorf("parseListenEnv(%q, %q, %q) returned error %v, expected ok %v", c.pid, c.fds, c.fdNames, err, c.ok)
+		}
+		if len(files) != 0 {
+			t.Errorf("parseListenEnv(%q, %q, %q) returned %d files, expected none", c.pid, c.fds, c.fdNames, len(files))
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build unix
+
+package systemd
+
+import "syscall"
+
+// closeOnExec marks the file descriptor to be closed on exec,
+// so that it is not leaked to child processes.
+func closeOnExec(fd int) {
+	syscall.CloseOnExec(fd)
+}
@@ -0,0 +1,105 @@
+//go:build unix
+
+package systemd
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listenTestChildEnv is set in the environment of the child process of [TestListenUDP].
+const listenTestChildEnv = "OPDT_TEST_LISTEN_CHILD"
+
+// listenTestPayload is sent by the parent to the socket passed to the child.
+const listenTestPayload = "hello from the parent"
+
+// TestListenUDP passes a UDP socket as fd 3 to a child process, like systemd socket activation,
+// and checks that the child receives on it.
+func TestListenUDP(t *testing.T) {
+	if addr := os.Getenv(listenTestChildEnv); addr != "" {
+		testListenUDPChild(t, addr)
+		return
+	}
+
+	uc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+
+	f, err := uc.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The datagram is queued on the socket until the child receives it.
+	laddr := uc.LocalAddr().(*net.UDPAddr).AddrPort()
+	if _, err = uc.WriteToUDPAddrPort([]byte(listenTestPayload), laddr); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestListenUDP$", "-test.v")
+	cmd.Env = append(os.Environ(),
+		listenTestChildEnv+"="+laddr.String(),
+		ListenFDsEnv+"=1",
+		ListenFDNamesEnv+"=opdt-go",
+	)
+	cmd.ExtraFiles = []*os.File{f}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Child process failed: %v\n%s", err, out)
+	}
+}
+
+func testListenUDPChild(t *testing.T, addr string) {
+	// systemd sets LISTEN_PID after forking, which the parent cannot do before starting the child.
+	pid := strconv.Itoa(os.Getpid())
+
+	files, err := parseListenEnv(strconv.Itoa(os.Getpid()+1), "1", "opdt-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("Got %d files for a mismatched %s, expected none", len(files), ListenPIDEnv)
+	}
+
+	os.Setenv(ListenPIDEnv, pid)
+
+	uc, err := ListenUDP("opdt-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+
+	for _, env := range []string{ListenPIDEnv, ListenFDsEnv, ListenFDNamesEnv} {
+		if v, ok := os.LookupEnv(env); ok {
+			t.Errorf("Got %s=%q, expected it to be unset", env, v)
+		}
+	}
+
+	if laddr := uc.LocalAddr().(*net.UDPAddr).AddrPort(); laddr != netip.MustParseAddrPort(addr) {
+		t.Errorf("Got local address %s, expected %s", laddr, addr)
+	}
+
+	if err = uc.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 64)
+	n, err := uc.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != listenTestPayload {
+		t.Errorf("Got payload %q, expected %q", b[:n], listenTestPayload)
+	}
+
+	if _, err = ListenUDP("missing"); !errors.Is(err, ErrSocketNotFound) {
+		t.Errorf("Got error %v, expected %v", err, ErrSocketNotFound)
+	}
+}
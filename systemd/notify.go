@@ -0,0 +1,96 @@
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variables set by systemd for service notifications.
+const (
+	NotifySocketEnv = "NOTIFY_SOCKET"
+	WatchdogUSecEnv = "WATCHDOG_USEC"
+	WatchdogPIDEnv  = "WATCHDOG_PID"
+)
+
+// Service states sent with [Notifier.Notify].
+const (
+	StateReady    = "READY=1"
+	StateStopping = "STOPPING=1"
+	StateWatchdog = "WATCHDOG=1"
+)
+
+var ErrUnsupportedNotifySocket = errors.New("unsupported notify socket address")
+
+// Notifier sends service state notifications to systemd over the sd_notify protocol.
+//
+// A nil Notifier discards all notifications, so that callers need not check
+// whether the process runs as a systemd service.
+type Notifier struct {
+	conn *net.UnixConn
+}
+
+// NewNotifier returns a notifier connected to the socket in $NOTIFY_SOCKET,
+// or nil if it is not set.
+func NewNotifier() (*Notifier, error) {
+	address := os.Getenv(NotifySocketEnv)
+	if address == "" {
+		return nil, nil
+	}
+
+	// Abstract socket addresses start with '@', which the net package translates on Linux.
+	if address[0] != '/' && address[0] != '@' {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedNotifySocket, address)
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: address, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &Notifier{conn: conn}, nil
+}
+
+// Notify sends the newline-separated state assignments, such as [StateReady].
+func (n *Notifier) Notify(state string) error {
+	if n == nil {
+		return nil
+	}
+	_, err := n.conn.Write([]byte(state))
+	return err
+}
+
+// Status sends a free-form status line, shown by systemctl status.
+func (n *Notifier) Status(status string) error {
+	return n.Notify("STATUS=" + strings.ReplaceAll(status, "\n", " "))
+}
+
+// Close closes the connection to the notify socket.
+func (n *Notifier) Close() error {
+	if n == nil {
+		return nil
+	}
+	return n.conn.Close()
+}
+
+// WatchdogTimeout returns the watchdog timeout configured by WatchdogSec= for this process,
+// or 0 if the watchdog is disabled. [StateWatchdog] must be sent more often than the timeout,
+// usually at half of it.
+func WatchdogTimeout() (time.Duration, error) {
+	usec := os.Getenv(WatchdogUSecEnv)
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv(WatchdogPIDEnv); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseUint(usec, 10, 63)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("bad %s %q", WatchdogUSecEnv, usec)
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
@@ -0,0 +1,78 @@
+//go:build unix
+
+package systemd
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	t.Setenv(NotifySocketEnv, path)
+	n, err := NewNotifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	if err = n.Notify(StateReady); err != nil {
+		t.Fatal(err)
+	}
+	if err = n.Status("line 1\nline 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"READY=1", "STATUS=line 1 line 2"} {
+		if err = conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 64)
+		nr, err := conn.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b[:nr]); got != expected {
+			t.Errorf("Got notification %q, expected %q", got, expected)
+		}
+	}
+}
+
+func TestNilNotifier(t *testing.T) {
+	t.Setenv(NotifySocketEnv, "")
+	n, err := NewNotifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != nil {
+		t.Fatal("Got notifier without $NOTIFY_SOCKET, expected nil")
+	}
+	if err = n.Notify(StateReady); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchdogTimeout(t *testing.T) {
+	t.Setenv(WatchdogPIDEnv, "")
+	t.Setenv(WatchdogUSecEnv, "30000000")
+	timeout, err := WatchdogTimeout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeout != 30*time.Second {
+		t.Errorf("Got watchdog timeout %v, expected 30s", timeout)
+	}
+
+	t.Setenv(WatchdogPIDEnv, "1")
+	if timeout, err = WatchdogTimeout(); err != nil || timeout != 0 {
+		t.Errorf("Got watchdog timeout %v, %v for another process, expected 0", timeout, err)
+	}
+}
@@ -0,0 +1,143 @@
+package validate
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+var ErrUnknownField = errors.New("unknown field")
+
+var (
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// DecodeError returns err, the error of decoding the JSON document data into v
+// with unknown fields disallowed, as a problem at the path of the offending value,
+// such as "servers[0].keys[1].psk".
+//
+// The errors of encoding/json do not carry array indices, and unknown fields carry no path at all,
+// so the document is walked along the type of v to find the first value that fails to decode on its own.
+// If none is found, the problem has an empty path and err.
+func DecodeError(data []byte, v any, err error) Problem {
+	if path, verr := locateJSONError(data, reflect.TypeOf(v), ""); verr != nil {
+		return Problem{Path: path, Err: verr}
+	}
+	return Problem{Err: err}
+}
+
+// locateJSONError returns the path and the error of the first value in the JSON document data,
+// at path, that fails to decode into a value of type t, or a nil error if there is none.
+func locateJSONError(data []byte, t reflect.Type, path string) (string, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	pt := reflect.PointerTo(t)
+	if !pt.Implements(jsonUnmarshalerType) && !pt.Implements(textUnmarshalerType) {
+		var (
+			p   string
+			err error
+		)
+		switch t.Kind() {
+		case reflect.Struct:
+			p, err = locateJSONObjectError(data, path, func(key string) (reflect.Type, string, bool) {
+				return jsonField(t, key)
+			})
+		case reflect.Map:
+			if t.Key().Kind() == reflect.String {
+				p, err = locateJSONObjectError(data, path, func(key string) (reflect.Type, string, bool) {
+					return t.Elem(), key, true
+				})
+			}
+		case reflect.Slice, reflect.Array:
+			if t.Elem().Kind() != reflect.Uint8 {
+				p, err = locateJSONArrayError(data, t.Elem(), path)
+			}
+		}
+		if err != nil {
+			return p, err
+		}
+	}
+
+	// Decode the value as a whole, which also catches a value of the wrong kind for a composite type.
+	if err := json.Unmarshal(data, reflect.New(t).Interface()); err != nil {
+		return path, err
+	}
+	return "", nil
+}
+
+// locateJSONObjectError walks the members of the JSON object data at path.
+// field returns the type and name of the value of a member, or false if the member is unknown.
+func locateJSONObjectError(data []byte, path string, field func(key string) (reflect.Type, string, bool)) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		// Not an object, left to the caller to decode as a whole.
+		return "", nil
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return path, err
+		}
+		key := tok.(string)
+
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return path, err
+		}
+
+		t, name, ok := field(key)
+		if !ok {
+			return Field(path, key), ErrUnknownField
+		}
+		if p, err := locateJSONError(value, t, Field(path, name)); err != nil {
+			return p, err
+		}
+	}
+	return "", nil
+}
+
+// locateJSONArrayError walks the elements of type t of the JSON array data at path.
+func locateJSONArrayError(data []byte, t reflect.Type, path string) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return "", nil
+	}
+
+	for i := 0; dec.More(); i++ {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return path, err
+		}
+		if p, err := locateJSONError(value, t, Index(path, i)); err != nil {
+			return p, err
+		}
+	}
+	return "", nil
+}
+
+// jsonField returns the type and JSON name of the field of struct type t that encoding/json decodes key into.
+func jsonField(t reflect.Type, key string) (reflect.Type, string, bool) {
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous && f.Type.Kind() == reflect.Struct {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = f.Name
+		}
+		if strings.EqualFold(name, key) {
+			return f.Type, name, true
+		}
+	}
+	return nil, "", false
+}
@@ -0,0 +1,52 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+type testConfig struct {
+	Servers []testServerConfig `json:"servers"`
+	Labels  map[string]int     `json:"labels"`
+}
+
+type testServerConfig struct {
+	Listen  string         `json:"listen"`
+	Allowed []netip.Prefix `json:"allowedClients"`
+	Limit   struct {
+		Burst int `json:"burst"`
+	} `json:"rateLimit"`
+}
+
+func TestDecodeError(t *testing.T) {
+	for _, c := range []struct {
+		doc     string
+		path    string
+		unknown bool
+	}{
+		{`{"servers":[{"listen":"a"},{"rateLimit":{"burst":"5"}}]}`, "servers[1].rateLimit.burst", false},
+		{`{"servers":[{"allowedClients":["192.0.2.0/24","bad"]}]}`, "servers[0].allowedClients[1]", false},
+		{`{"servers":[{"Listen":"a","rateLimit":{"burts":5}}]}`, "servers[0].rateLimit.burts", true},
+		{`{"labels":{"a":1,"b":"2"}}`, "labels.b", false},
+		{`{"servers":{}}`, "servers", false},
+	} {
+		var v testConfig
+		dec := json.NewDecoder(strings.NewReader(c.doc))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&v)
+		if err == nil {
+			t.Fatalf("Decoding %s succeeded, expected an error", c.doc)
+		}
+
+		p := DecodeError([]byte(c.doc), &v, err)
+		if p.Path != c.path {
+			t.Errorf("Got path %q for %s, expected %q", p.Path, c.doc, c.path)
+		}
+		if got := errors.Is(p, ErrUnknownField); got != c.unknown {
+			t.Errorf("Got unknown field %v for %s, expected %v", got, c.doc, c.unknown)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Package validate collects problems found in configurations,
+// each qualified by the JSON path of the setting it is about.
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Problem is a problem with the setting at Path, such as "servers[0].listen".
+type Problem struct {
+	Path string
+
+	Err error
+
+	// Warning is true if the setting is valid, but risky.
+	Warning bool
+}
+
+// Error implements [error].
+func (p Problem) Error() string {
+	if p.Path == "" {
+		return p.Err.Error()
+	}
+	return p.Path + ": " + p.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (p Problem) Unwrap() error {
+	return p.Err
+}
+
+// Problems is a list of problems found in a configuration.
+type Problems []Problem
+
+// Add adds err as an error at path.
+func (ps *Problems) Add(path string, err error) {
+	*ps = append(*ps, Problem{Path: path, Err: err})
+}
+
+// Addf adds an error at path, formatted as [fmt.Errorf] does.
+func (ps *Problems) Addf(path, format string, a ...any) {
+	ps.Add(path, fmt.Errorf(format, a...))
+}
+
+// Warnf adds a warning at path, formatted as [fmt.Errorf] does.
+func (ps *Problems) Warnf(path, format string, a ...any) {
+	*ps = append(*ps, Problem{Path: path, Err: fmt.Errorf(format, a...), Warning: true})
+}
+
+// HasErrors returns whether any of the problems is an error.
+func (ps Problems) HasErrors() bool {
+	for _, p := range ps {
+		if !p.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns the errors joined, or nil if there are only warnings.
+func (ps Problems) Err() error {
+	var errs []error
+	for _, p := range ps {
+		if !p.Warning {
+			errs = append(errs, p)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Field returns the path of the field name of the object at path.
+func Field(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// Index returns the path of the i-th element of the array at path.
+func Index(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
@@ -0,0 +1,28 @@
+package validate
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestProblems(t *testing.T) {
+	var ps Problems
+	ps.Warnf(Field(Index("servers", 0), "listen"), "listening on all addresses")
+	if ps.HasErrors() || ps.Err() != nil {
+		t.Errorf("Expected warnings not to be errors, got %v", ps.Err())
+	}
+
+	errBad := errors.New("bad")
+	ps.Add(Field(Field(Index("servers", 1), "rateLimit"), "burst"), errBad)
+	if !ps.HasErrors() {
+		t.Error("Expected an error")
+	}
+
+	err := ps.Err()
+	if !errors.Is(err, errBad) {
+		t.Errorf("Got error %v, expected it to wrap %v", err, errBad)
+	}
+	if got, want := err.Error(), "servers[1].rateLimit.burst: bad"; got != want {
+		t.Errorf("Got error message %q, expected %q", got, want)
+	}
+}